@@ -0,0 +1,227 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	defaultRateLimitBurst = 10
+	// idle clients rate limiters are removed after this duration
+	rateLimiterIdleTimeout = 10 * time.Minute
+	// min interval between two telemetry updates triggered by rejected scrapes
+	rejectionsTelemetryInterval = 5 * time.Second
+)
+
+type rateLimit struct {
+	RequestsPerMinute uint32Value `json:"requests_per_minute,omitempty"`
+	Burst             uint32Value `json:"burst,omitempty"`
+}
+
+// rejectedScrapes counts the scrape requests rejected by the exporter.
+type rejectedScrapes struct {
+	NotAllowed      uint64Value `json:"not_allowed,omitempty"`
+	RateLimited     uint64Value `json:"rate_limited,omitempty"`
+	Unauthenticated uint64Value `json:"unauthenticated,omitempty"`
+
+	// unix nanoseconds of the last telemetry update triggered by a rejection
+	lastTelemetryUpdate int64
+}
+
+// MarshalJSON marshals a snapshot of the counters,
+// they are incremented concurrently by the HTTP handlers.
+func (rs *rejectedScrapes) MarshalJSON() ([]byte, error) {
+	type snapshot rejectedScrapes
+	return json.Marshal(&snapshot{
+		NotAllowed:      uint64Value{Value: atomic.LoadUint64(&rs.NotAllowed.Value)},
+		RateLimited:     uint64Value{Value: atomic.LoadUint64(&rs.RateLimited.Value)},
+		Unauthenticated: uint64Value{Value: atomic.LoadUint64(&rs.Unauthenticated.Value)},
+	})
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiters holds a token bucket per client IP address.
+type rateLimiters struct {
+	m         *sync.Mutex
+	clients   map[string]*clientLimiter
+	limit     rate.Limit
+	burst     int
+	lastSweep time.Time
+}
+
+func newRateLimiters() *rateLimiters {
+	return &rateLimiters{
+		m:       new(sync.Mutex),
+		clients: make(map[string]*clientLimiter),
+	}
+}
+
+// reserve takes a token from the bucket of client ip,
+// it returns 0 if the request is allowed, or the time to wait for a token.
+func (rl *rateLimiters) reserve(ip string, limit rate.Limit, burst int) time.Duration {
+	rl.m.Lock()
+	defer rl.m.Unlock()
+	now := time.Now()
+	if limit != rl.limit || burst != rl.burst {
+		// rate limit changed, start over
+		rl.clients = make(map[string]*clientLimiter)
+		rl.limit, rl.burst = limit, burst
+	}
+	if now.Sub(rl.lastSweep) > rateLimiterIdleTimeout {
+		for k, c := range rl.clients {
+			if now.Sub(c.lastSeen) > rateLimiterIdleTimeout {
+				delete(rl.clients, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+	c, ok := rl.clients[ip]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(limit, burst)}
+		rl.clients[ip] = c
+	}
+	c.lastSeen = now
+	r := c.limiter.ReserveN(now, 1)
+	if !r.OK() {
+		return time.Duration(math.MaxInt64)
+	}
+	if d := r.DelayFrom(now); d > 0 {
+		r.CancelAt(now)
+		return d
+	}
+	return 0
+}
+
+// clientIP returns the IP address of the request client.
+func clientIP(r *http.Request) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
+
+// parseAllowedPrefixes parses the configured allowed prefixes,
+// invalid prefixes are logged and skipped.
+func parseAllowedPrefixes(ps []stringValue) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(ps))
+	for _, p := range ps {
+		prefix, err := netip.ParsePrefix(p.Value)
+		if err != nil {
+			log.Errorf("invalid allowed-prefix %q: %v", p.Value, err)
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+// clientAllowed returns true if addr is within one of the allowed prefixes,
+// or if no prefix is configured.
+// assumes config is already locked
+func (s *server) clientAllowed(addr netip.Addr) bool {
+	if len(s.config.baseConfig.AllowedPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range s.config.baseConfig.allowedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientRateLimit returns the configured rate limit,
+// the returned bool is false if rate limiting is disabled.
+// assumes config is already locked
+func (s *server) clientRateLimit() (rate.Limit, int, bool) {
+	rl := s.config.baseConfig.RateLimit
+	if rl == nil || rl.RequestsPerMinute.Value == 0 {
+		return 0, 0, false
+	}
+	burst := int(rl.Burst.Value)
+	if burst == 0 {
+		burst = defaultRateLimitBurst
+	}
+	return rate.Limit(float64(rl.RequestsPerMinute.Value) / 60), burst, true
+}
+
+// withAccessControl wraps handler with the source address allow list
+// and the per client rate limit checks.
+func (s *server) withAccessControl(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, err := clientIP(r)
+		if err != nil {
+			log.Errorf("failed to parse client address %q: %v", r.RemoteAddr, err)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		s.config.m.Lock()
+		allowed := s.clientAllowed(addr)
+		limit, burst, limited := s.clientRateLimit()
+		s.config.m.Unlock()
+		if !allowed {
+			log.Debugf("scrape request from %s not allowed", addr)
+			s.countRejection(&s.rejected.NotAllowed)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		if limited {
+			if wait := s.rateLimiters.reserve(addr.String(), limit, burst); wait > 0 {
+				log.Debugf("scrape request from %s rate limited", addr)
+				s.countRejection(&s.rejected.RateLimited)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// countRejection increments counter and updates the exporter telemetry,
+// at most once every rejectionsTelemetryInterval.
+func (s *server) countRejection(counter *uint64Value) {
+	atomic.AddUint64(&counter.Value, 1)
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&s.rejected.lastTelemetryUpdate)
+	if now-last < int64(rejectionsTelemetryInterval) ||
+		!atomic.CompareAndSwapInt64(&s.rejected.lastTelemetryUpdate, last, now) {
+		return
+	}
+	go func() {
+		// snapshot the config and counters under the lock,
+		// the config handlers update the base config concurrently
+		s.config.m.Lock()
+		jsData, err := json.Marshal(s.config.baseConfig)
+		s.config.m.Unlock()
+		if err != nil {
+			log.Errorf("failed to marshal json data: %v", err)
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), retryInterval)
+		defer cancel()
+		ctx = metadata.AppendToOutgoingContext(ctx, "agent_name", s.config.agentName)
+		s.updateTelemetry(ctx, exporterPath, string(jsData))
+	}()
+}
@@ -0,0 +1,91 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func TestWithAccessControlAllowList(t *testing.T) {
+	tests := []struct {
+		name       string
+		prefixes   []string
+		remoteAddr string
+		want       int
+	}{
+		{name: "no allow list", remoteAddr: "192.0.2.1:5000", want: http.StatusOK},
+		{name: "allowed ipv4", prefixes: []string{"192.0.2.0/24"}, remoteAddr: "192.0.2.1:5000", want: http.StatusOK},
+		{name: "not allowed ipv4", prefixes: []string{"192.0.2.0/24"}, remoteAddr: "198.51.100.1:5000", want: http.StatusForbidden},
+		{name: "allowed ipv6", prefixes: []string{"192.0.2.0/24", "2001:db8::/32"}, remoteAddr: "[2001:db8::1]:5000", want: http.StatusOK},
+		{name: "not allowed ipv6", prefixes: []string{"2001:db8::/32"}, remoteAddr: "[2001:db9::1]:5000", want: http.StatusForbidden},
+		{name: "ipv4 mapped ipv6", prefixes: []string{"192.0.2.0/24"}, remoteAddr: "[::ffff:192.0.2.1]:5000", want: http.StatusOK},
+		{name: "host prefix", prefixes: []string{"192.0.2.1/32"}, remoteAddr: "192.0.2.2:5000", want: http.StatusForbidden},
+		{name: "only invalid prefixes", prefixes: []string{"not-a-prefix"}, remoteAddr: "192.0.2.1:5000", want: http.StatusForbidden},
+		{name: "invalid client address", prefixes: []string{"192.0.2.0/24"}, remoteAddr: "unknown", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewConfig(&FileConfig{}, "prometheus-exporter", false)
+			for _, p := range tt.prefixes {
+				cfg.baseConfig.AllowedPrefixes = append(cfg.baseConfig.AllowedPrefixes, stringValue{Value: p})
+			}
+			cfg.baseConfig.allowedPrefixes = parseAllowedPrefixes(cfg.baseConfig.AllowedPrefixes)
+			s := NewServer(WithConfig(cfg))
+			muteRejectionsTelemetry(s)
+
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			r.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+			s.withAccessControl(okHandler).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, expected %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusForbidden && tt.remoteAddr != "unknown" && s.rejected.NotAllowed.Value != 1 {
+				t.Errorf("expected 1 not allowed rejection, got %d", s.rejected.NotAllowed.Value)
+			}
+		})
+	}
+}
+
+func TestWithAccessControlRateLimit(t *testing.T) {
+	cfg := NewConfig(&FileConfig{}, "prometheus-exporter", false)
+	cfg.baseConfig.RateLimit = &rateLimit{
+		RequestsPerMinute: uint32Value{Value: 6},
+		Burst:             uint32Value{Value: 2},
+	}
+	s := NewServer(WithConfig(cfg))
+	muteRejectionsTelemetry(s)
+	handler := s.withAccessControl(okHandler)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		want       int
+		retryAfter string
+	}{
+		{name: "first request", remoteAddr: "192.0.2.1:5000", want: http.StatusOK},
+		{name: "second request within the burst", remoteAddr: "192.0.2.1:5001", want: http.StatusOK},
+		{name: "burst exhausted", remoteAddr: "192.0.2.1:5002", want: http.StatusTooManyRequests, retryAfter: "10"},
+		{name: "rejected requests do not take a token", remoteAddr: "192.0.2.1:5003", want: http.StatusTooManyRequests, retryAfter: "10"},
+		{name: "other client has its own bucket", remoteAddr: "192.0.2.2:5000", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			r.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, expected %d", w.Code, tt.want)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("got Retry-After %q, expected %q", got, tt.retryAfter)
+			}
+		})
+	}
+	if s.rejected.RateLimited.Value != 2 {
+		t.Errorf("expected 2 rate limited rejections, got %d", s.rejected.RateLimited.Value)
+	}
+}
@@ -0,0 +1,185 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/nokia/srlinux-ndk-go/ndk"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	authRealm = serviceName
+	// max number of cached successful bcrypt verifications
+	maxAuthCacheSize = 1024
+)
+
+type authUserConfig struct {
+	User struct {
+		PasswordHash secretValue   `json:"password_hash,omitempty"`
+		Metrics      []stringValue `json:"metrics,omitempty"`
+	} `json:"user,omitempty"`
+}
+
+type authTokenConfig struct {
+	Token struct {
+		Token   secretValue   `json:"token,omitempty"`
+		Metrics []stringValue `json:"metrics,omitempty"`
+	} `json:"token,omitempty"`
+}
+
+// authCache keeps the successful bcrypt verifications,
+// bcrypt being too slow to run on each scrape.
+type authCache struct {
+	m        *sync.Mutex
+	verified map[[sha256.Size]byte]struct{}
+}
+
+func newAuthCache() *authCache {
+	return &authCache{
+		m:        new(sync.Mutex),
+		verified: make(map[[sha256.Size]byte]struct{}),
+	}
+}
+
+// verify checks password against the bcrypt hash.
+func (c *authCache) verify(hash, username, password string) bool {
+	key := sha256.Sum256([]byte(hash + "\x00" + username + "\x00" + password))
+	c.m.Lock()
+	_, ok := c.verified[key]
+	c.m.Unlock()
+	if ok {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	if len(c.verified) >= maxAuthCacheSize {
+		c.verified = make(map[[sha256.Size]byte]struct{})
+	}
+	c.verified[key] = struct{}{}
+	return true
+}
+
+// authEnabled returns true if at least one user or token is configured.
+// assumes config is already locked
+func (s *server) authEnabled() bool {
+	return len(s.config.authUsers) > 0 || len(s.config.authTokens) > 0
+}
+
+// authenticate checks the request credentials.
+// It returns the metrics the client is allowed to scrape, nil if it is not restricted,
+// and false if the request is not authenticated.
+func (s *server) authenticate(r *http.Request) ([]string, bool) {
+	s.config.m.Lock()
+	if !s.authEnabled() {
+		s.config.m.Unlock()
+		return nil, true
+	}
+	authz := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(authz, "Bearer "); ok {
+		defer s.config.m.Unlock()
+		token = strings.TrimSpace(token)
+		for _, t := range s.config.authTokens {
+			if t.Token.Token.Value != "" &&
+				subtle.ConstantTimeCompare([]byte(t.Token.Token.Value), []byte(token)) == 1 {
+				return scope(t.Token.Metrics), true
+			}
+		}
+		return nil, false
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		s.config.m.Unlock()
+		return nil, false
+	}
+	u, ok := s.config.authUsers[username]
+	if !ok {
+		s.config.m.Unlock()
+		return nil, false
+	}
+	hash := u.User.PasswordHash.Value
+	metrics := scope(u.User.Metrics)
+	// do not hold the config lock while running bcrypt
+	s.config.m.Unlock()
+	if !s.authCache.verify(hash, username, password) {
+		return nil, false
+	}
+	return metrics, true
+}
+
+func scope(metrics []stringValue) []string {
+	if len(metrics) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		names = append(names, m.Value)
+	}
+	return names
+}
+
+// withAuth wraps handler with the authentication check,
+// the allowed metrics are applied to the scrape filter.
+func (s *server) withAuth(handler func(w http.ResponseWriter, r *http.Request, allowed []string)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed, ok := s.authenticate(r)
+		if !ok {
+			log.Debugf("unauthenticated scrape request from %s", r.RemoteAddr)
+			s.countRejection(&s.rejected.Unauthenticated)
+			w.Header().Add("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", authRealm))
+			w.Header().Add("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", authRealm))
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		handler(w, r, allowed)
+	})
+}
+
+func (s *server) handleCfgAuthUserCreateChange(ctx context.Context, cfg *ndk.ConfigNotification) {
+	username := cfg.Key.Keys[0]
+	newUserConfig := new(authUserConfig)
+	err := json.Unmarshal([]byte(cfg.GetData().GetJson()), newUserConfig)
+	if err != nil {
+		log.Errorf("failed to marshal config data from path %s: %v", cfg.Key.JsPath, err)
+		return
+	}
+	if _, err := bcrypt.Cost([]byte(newUserConfig.User.PasswordHash.Value)); err != nil {
+		log.Errorf("user %q: password-hash is not a valid bcrypt hash: %v", username, err)
+	}
+	s.config.authUsers[username] = newUserConfig
+	s.updateAuthUserTelemetry(ctx, username, newUserConfig)
+}
+
+func (s *server) handleCfgAuthUserDelete(ctx context.Context, cfg *ndk.ConfigNotification) {
+	username := cfg.Key.Keys[0]
+	delete(s.config.authUsers, username)
+	s.deleteAuthUserTelemetry(ctx, username)
+}
+
+func (s *server) handleCfgAuthTokenCreateChange(ctx context.Context, cfg *ndk.ConfigNotification) {
+	name := cfg.Key.Keys[0]
+	newTokenConfig := new(authTokenConfig)
+	err := json.Unmarshal([]byte(cfg.GetData().GetJson()), newTokenConfig)
+	if err != nil {
+		log.Errorf("failed to marshal config data from path %s: %v", cfg.Key.JsPath, err)
+		return
+	}
+	s.config.authTokens[name] = newTokenConfig
+	s.updateAuthTokenTelemetry(ctx, name, newTokenConfig)
+}
+
+func (s *server) handleCfgAuthTokenDelete(ctx context.Context, cfg *ndk.ConfigNotification) {
+	name := cfg.Key.Keys[0]
+	delete(s.config.authTokens, name)
+	s.deleteAuthTokenTelemetry(ctx, name)
+}
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// muteRejectionsTelemetry prevents the rejected scrapes counters
+// from being sent to the NDK telemetry, there is no agent in the tests.
+func muteRejectionsTelemetry(s *server) {
+	s.rejected.lastTelemetryUpdate = time.Now().Add(time.Hour).UnixNano()
+}
+
+func TestAuthenticate(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cr3t"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := NewConfig(&FileConfig{}, "prometheus-exporter", false)
+	for _, name := range []string{"interfaces", "bgp"} {
+		cfg.metrics[name] = new(metricConfig)
+		cfg.metrics[name].Metric.State = stateEnable
+	}
+	admin := new(authUserConfig)
+	admin.User.PasswordHash.Value = string(hash)
+	cfg.authUsers["admin"] = admin
+	ops := new(authUserConfig)
+	ops.User.PasswordHash.Value = string(hash)
+	ops.User.Metrics = []stringValue{{Value: "interfaces"}}
+	cfg.authUsers["ops"] = ops
+	scraper := new(authTokenConfig)
+	scraper.Token.Token.Value = "t0k3n"
+	scraper.Token.Metrics = []stringValue{{Value: "bgp"}}
+	cfg.authTokens["scraper"] = scraper
+	s := NewServer(WithConfig(cfg))
+	muteRejectionsTelemetry(s)
+
+	// same status codes as the metrics handler, without collecting the metrics
+	handler := s.withAuth(func(w http.ResponseWriter, r *http.Request, allowed []string) {
+		_, err := s.parseScrapeFilter(r, allowed)
+		if err == errForbiddenMetric {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	})
+
+	tests := []struct {
+		name     string
+		query    string
+		username string
+		password string
+		token    string
+		want     int
+	}{
+		{name: "no credentials", want: http.StatusUnauthorized},
+		{name: "unknown user", username: "guest", password: "s3cr3t", want: http.StatusUnauthorized},
+		{name: "wrong password", username: "admin", password: "wrong", want: http.StatusUnauthorized},
+		{name: "unrestricted user", username: "admin", password: "s3cr3t", want: http.StatusOK},
+		{name: "unrestricted user any metric", query: "collect[]=bgp", username: "admin", password: "s3cr3t", want: http.StatusOK},
+		{name: "restricted user", username: "ops", password: "s3cr3t", want: http.StatusOK},
+		{name: "restricted user allowed metric", query: "collect[]=interfaces", username: "ops", password: "s3cr3t", want: http.StatusOK},
+		{name: "restricted user forbidden metric", query: "collect[]=bgp", username: "ops", password: "s3cr3t", want: http.StatusForbidden},
+		{name: "restricted user forbidden metric in list", query: "collect=interfaces,bgp", username: "ops", password: "s3cr3t", want: http.StatusForbidden},
+		{name: "restricted user wrong password", query: "collect[]=bgp", username: "ops", password: "wrong", want: http.StatusUnauthorized},
+		{name: "token", token: "t0k3n", want: http.StatusOK},
+		{name: "token allowed metric", query: "collect[]=bgp", token: "t0k3n", want: http.StatusOK},
+		{name: "token forbidden metric", query: "collect[]=interfaces", token: "t0k3n", want: http.StatusForbidden},
+		{name: "wrong token", query: "collect[]=bgp", token: "wrong", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics?"+tt.query, nil)
+			if tt.username != "" {
+				r.SetBasicAuth(tt.username, tt.password)
+			}
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, expected %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if tt.want == http.StatusUnauthorized && len(w.Header().Values("WWW-Authenticate")) != 2 {
+				t.Errorf("expected Basic and Bearer challenges, got %v", w.Header().Values("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestAuthenticateDisabled(t *testing.T) {
+	s := NewServer(WithConfig(NewConfig(&FileConfig{}, "prometheus-exporter", false)))
+	allowed, ok := s.authenticate(httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !ok || allowed != nil {
+		t.Fatalf("got allowed=%v ok=%v, expected all metrics without credentials", allowed, ok)
+	}
+}
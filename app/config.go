@@ -3,6 +3,8 @@ package app
 import (
 	"context"
 	"encoding/json"
+	"net/netip"
+	"strconv"
 	"sync"
 	"time"
 
@@ -26,16 +28,49 @@ const (
 	exporterPath     = ".system.prometheus_exporter"
 	metricPath       = ".system.prometheus_exporter.metric"
 	customMetricPath = ".system.prometheus_exporter.custom_metric"
+	leafTypePath     = ".system.prometheus_exporter.custom_metric.leaf_type"
+	relabelRulePath  = ".system.prometheus_exporter.custom_metric.relabel_rule"
+	valueMapPath     = ".system.prometheus_exporter.custom_metric.value_map"
+
+	staticLabelPath             = ".system.prometheus_exporter.static_label"
+	metricStaticLabelPath       = ".system.prometheus_exporter.metric.static_label"
+	customMetricStaticLabelPath = ".system.prometheus_exporter.custom_metric.static_label"
+
+	authUserPath  = ".system.prometheus_exporter.authentication.user"
+	authTokenPath = ".system.prometheus_exporter.authentication.token"
+
+	remoteWriteEndpointPath = ".system.prometheus_exporter.remote_write.endpoint"
+	otlpHeaderPath          = ".system.prometheus_exporter.otlp.header"
+
+	scrapeProfilePath = ".system.prometheus_exporter.registration.consul.scrape_profile"
 )
 
 type stringValue struct {
 	Value string `json:"value,omitempty"`
 }
 
+// secretValue is a string leaf that is not copied to the state datastore,
+// nor logged: it is marshaled as an empty value.
+type secretValue struct {
+	Value string `json:"value,omitempty"`
+}
+
+func (secretValue) MarshalJSON() ([]byte, error) {
+	return []byte("{}"), nil
+}
+
 type uint64Value struct {
 	Value uint64 `json:"value,omitempty"`
 }
 
+type uint32Value struct {
+	Value uint32 `json:"value,omitempty"`
+}
+
+type int32Value struct {
+	Value int32 `json:"value,omitempty"`
+}
+
 type boolValue struct {
 	Value bool `json:"value,omitempty"`
 }
@@ -50,18 +85,31 @@ type config struct {
 	nwInst       map[string]*ndk.NetworkInstanceData
 	metrics      map[string]*metricConfig
 	customMetric map[string]*customMetricConfig
+	// exporter level static labels
+	staticLabels map[string]string
+	// metrics endpoint credentials
+	authUsers  map[string]*authUserConfig
+	authTokens map[string]*authTokenConfig
+	// remote write endpoints
+	remoteWriteEndpoints map[string]*remoteWriteEndpointConfig
+	// otlp export headers
+	otlpHeaders map[string]*otlpHeaderConfig
+	// consul registration scrape profiles
+	scrapeProfiles map[string]*scrapeProfileConfig
 
 	// from file
-	username string
-	password string
+	username      string
+	password      string
+	metricOptions map[string]*metricOptions
 	//
 	debug bool
 }
 
 type FileConfig struct {
-	Metrics  map[string][]string `yaml:"metrics,omitempty"`
-	Username string              `yaml:"username,omitempty"`
-	Password string              `yaml:"password,omitempty"`
+	Metrics       map[string][]string       `yaml:"metrics,omitempty"`
+	MetricOptions map[string]*metricOptions `yaml:"metric-options,omitempty"`
+	Username      string                    `yaml:"username,omitempty"`
+	Password      string                    `yaml:"password,omitempty"`
 }
 
 func NewConfig(fc *FileConfig, agentName string, debug bool) *config {
@@ -79,6 +127,26 @@ func NewConfig(fc *FileConfig, agentName string, debug bool) *config {
 		OperState:  operDown,
 	}
 
+	metricOpts := fc.MetricOptions
+	if metricOpts == nil {
+		metricOpts = make(map[string]*metricOptions)
+	}
+	for name, mo := range metricOpts {
+		if mo == nil {
+			continue
+		}
+		rules := make([]*relabelRule, 0, len(mo.Relabel))
+		for i, r := range mo.Relabel {
+			err := r.compile()
+			if err != nil {
+				log.Errorf("metric %q: ignoring relabel rule %d: %v", name, i, err)
+				continue
+			}
+			rules = append(rules, r)
+		}
+		mo.Relabel = rules
+	}
+
 	return &config{
 		agentName:    agentName,
 		baseConfig:   bcfg,
@@ -86,50 +154,140 @@ func NewConfig(fc *FileConfig, agentName string, debug bool) *config {
 		nwInst:       make(map[string]*ndk.NetworkInstanceData),
 		metrics:      kmetrics,
 		customMetric: make(map[string]*customMetricConfig),
-		username:     fc.Username,
-		password:     fc.Password,
-		debug:        debug,
+		staticLabels: make(map[string]string),
+		authUsers:    make(map[string]*authUserConfig),
+		authTokens:   make(map[string]*authTokenConfig),
+
+		remoteWriteEndpoints: make(map[string]*remoteWriteEndpointConfig),
+		otlpHeaders:          make(map[string]*otlpHeaderConfig),
+		scrapeProfiles:       make(map[string]*scrapeProfileConfig),
+		username:             fc.Username,
+		password:             fc.Password,
+		metricOptions:        metricOpts,
+		debug:                debug,
 	}
 }
 
 type baseConfig struct {
-	AdminState      string        `json:"admin_state,omitempty"`
-	OperState       string        `json:"oper_state,omitempty"`
-	NetworkInstance stringValue   `json:"network_instance,omitempty"`
-	Address         stringValue   `json:"address,omitempty"`
-	Port            stringValue   `json:"port,omitempty"`
-	HttpPath        stringValue   `json:"http_path,omitempty"`
-	ScrapesCount    uint64Value   `json:"scrapes_count,omitempty"`
-	Registration    *registration `json:"registration,omitempty"`
+	AdminState      string      `json:"admin_state,omitempty"`
+	OperState       string      `json:"oper_state,omitempty"`
+	NetworkInstance stringValue `json:"network_instance,omitempty"`
+	Address         stringValue `json:"address,omitempty"`
+	Port            stringValue `json:"port,omitempty"`
+	HttpPath        stringValue `json:"http_path,omitempty"`
+	TLSProfile      stringValue `json:"tls_profile,omitempty"`
+	LabelNaming     string      `json:"label_naming,omitempty"`
+	MetricNaming    string      `json:"metric_naming,omitempty"`
+	MetricPrefix    stringValue `json:"metric_prefix,omitempty"`
+	RuntimeMetrics  boolValue   `json:"runtime_metrics,omitempty"`
+	ScrapeTimeout   stringValue `json:"scrape_timeout,omitempty"`
+	ScrapesCount    uint64Value `json:"scrapes_count,omitempty"`
+	// gNMI unix socket connection state
+	GNMIConnectionState string            `json:"gnmi_connection_state,omitempty"`
+	Registration        *registration     `json:"registration,omitempty"`
+	Streaming           *streaming        `json:"streaming,omitempty"`
+	IdentityLabels      *identityLabels   `json:"identity_labels,omitempty"`
+	AllowedPrefixes     []stringValue     `json:"allowed_prefixes,omitempty"`
+	RateLimit           *rateLimit        `json:"rate_limit,omitempty"`
+	RemoteWrite         *remoteWrite      `json:"remote_write,omitempty"`
+	OTLP                *otlp             `json:"otlp,omitempty"`
+	Pushgateway         *pushgateway      `json:"pushgateway,omitempty"`
+	DeviceTimestamps    *deviceTimestamps `json:"device_timestamps,omitempty"`
+	// rejected scrapes counters, shared by all the config versions
+	RejectedScrapes *rejectedScrapes `json:"rejected_scrapes,omitempty"`
+
+	// parsed AllowedPrefixes
+	allowedPrefixes []netip.Prefix
 }
 
 type metricConfig struct {
 	Metric metric `json:"metric,omitempty"`
+	// static labels, from the static-label list
+	staticLabels map[string]string
 }
 
 type customMetricConfig struct {
 	Metric metric `json:"custom_metric,omitempty"`
+	// leaf name to value type, from the leaf-type list
+	leafTypes map[string]string
+	// relabel rules per index, from the relabel-rule list
+	relabelRules map[int]*relabelRule
+	// string value to number, from the value-map list
+	valueMap map[string]float64
+	// static labels, from the static-label list
+	staticLabels map[string]string
 }
 
 type metric struct {
 	State    string        `json:"state,omitempty"`
 	HelpText stringValue   `json:"help_text,omitempty"`
 	Paths    []stringValue `json:"paths,omitempty"`
+	Type     string        `json:"type,omitempty"`
+	// overrides the exporter scrape-timeout
+	ScrapeTimeout stringValue `json:"scrape_timeout,omitempty"`
+	// series selectors
+	Include []stringValue `json:"include,omitempty"`
+	Exclude []stringValue `json:"exclude,omitempty"`
+	// custom metrics only
+	Mode       string        `json:"mode,omitempty"`
+	InfoLeaves []stringValue `json:"info_leaves,omitempty"`
+}
+
+type relabelRuleConfig struct {
+	RelabelRule struct {
+		Action       string        `json:"action,omitempty"`
+		SourceLabels []stringValue `json:"source_labels,omitempty"`
+		Separator    stringValue   `json:"separator,omitempty"`
+		Regex        stringValue   `json:"regex,omitempty"`
+		TargetLabel  stringValue   `json:"target_label,omitempty"`
+		Replacement  stringValue   `json:"replacement,omitempty"`
+		Modulus      uint32Value   `json:"modulus,omitempty"`
+	} `json:"relabel_rule,omitempty"`
+}
+
+type staticLabelConfig struct {
+	StaticLabel struct {
+		Value stringValue `json:"value,omitempty"`
+	} `json:"static_label,omitempty"`
+}
+
+type valueMapConfig struct {
+	ValueMap struct {
+		Number int32Value `json:"number,omitempty"`
+	} `json:"value_map,omitempty"`
+}
+
+type leafTypeConfig struct {
+	LeafType struct {
+		Type string `json:"type,omitempty"`
+	} `json:"leaf_type,omitempty"`
 }
 
 type registration struct {
-	Address    stringValue   `json:"address,omitempty"`
-	Username   stringValue   `json:"username,omitempty"`
-	Password   stringValue   `json:"password,omitempty"`
-	Token      stringValue   `json:"token,omitempty"`
-	TTL        stringValue   `json:"ttl,omitempty"`
-	HTTPCheck  boolValue     `json:"http-check,omitempty"`
-	Tags       []stringValue `json:"tags,omitempty"`
-	AdminState string        `json:"admin_state,omitempty"`
-	OperState  string        `json:"oper_state,omitempty"`
+	Backend   string        `json:"backend,omitempty"`
+	Address   stringValue   `json:"address,omitempty"`
+	Username  stringValue   `json:"username,omitempty"`
+	Password  secretValue   `json:"password,omitempty"`
+	Token     secretValue   `json:"token,omitempty"`
+	TTL       stringValue   `json:"ttl,omitempty"`
+	HTTPCheck boolValue     `json:"http-check,omitempty"`
+	Tags      []stringValue `json:"tags,omitempty"`
+	// text/template of the service name and ID
+	ServiceName stringValue   `json:"service_name,omitempty"`
+	ServiceID   stringValue   `json:"service_id,omitempty"`
+	KeyPrefix   stringValue   `json:"key_prefix,omitempty"`
+	FilePath    stringValue   `json:"file_path,omitempty"`
+	Consul      *consulConfig `json:"consul,omitempty"`
+	Health      *healthConfig `json:"health,omitempty"`
+	AdminState  string        `json:"admin_state,omitempty"`
+	OperState   string        `json:"oper_state,omitempty"`
 }
 
 func (s *server) ConfigHandler(ctx context.Context) {
+	err := s.gnmi.start(ctx)
+	if err != nil {
+		log.Errorf("failed to create gnmi connection to %q: %v", gnmiServerUnixSocket, err)
+	}
 	cfgStream := s.agent.StartConfigNotificationStream(ctx)
 	nwInstStream := s.agent.StartNwInstNotificationStream(ctx)
 	for {
@@ -227,6 +385,105 @@ func (s *server) handleConfigEvent(ctx context.Context, cfg *ndk.ConfigNotificat
 			case ndk.SdkMgrOperation_Delete:
 				s.handleCfgCustomMetricDelete(ctx, txCfg)
 			}
+		case leafTypePath:
+			if len(txCfg.Key.Keys) < 2 {
+				log.Errorf("%q missing keys in cfg notification: %+v", leafTypePath, txCfg)
+				return
+			}
+			switch txCfg.Op {
+			case ndk.SdkMgrOperation_Create, ndk.SdkMgrOperation_Update:
+				s.handleCfgLeafTypeCreateChange(ctx, txCfg)
+			case ndk.SdkMgrOperation_Delete:
+				s.handleCfgLeafTypeDelete(ctx, txCfg)
+			}
+		case relabelRulePath:
+			if len(txCfg.Key.Keys) < 2 {
+				log.Errorf("%q missing keys in cfg notification: %+v", relabelRulePath, txCfg)
+				return
+			}
+			switch txCfg.Op {
+			case ndk.SdkMgrOperation_Create, ndk.SdkMgrOperation_Update:
+				s.handleCfgRelabelRuleCreateChange(ctx, txCfg)
+			case ndk.SdkMgrOperation_Delete:
+				s.handleCfgRelabelRuleDelete(ctx, txCfg)
+			}
+		case valueMapPath:
+			if len(txCfg.Key.Keys) < 2 {
+				log.Errorf("%q missing keys in cfg notification: %+v", valueMapPath, txCfg)
+				return
+			}
+			switch txCfg.Op {
+			case ndk.SdkMgrOperation_Create, ndk.SdkMgrOperation_Update:
+				s.handleCfgValueMapCreateChange(ctx, txCfg)
+			case ndk.SdkMgrOperation_Delete:
+				s.handleCfgValueMapDelete(ctx, txCfg)
+			}
+		case staticLabelPath, metricStaticLabelPath, customMetricStaticLabelPath:
+			if len(txCfg.Key.Keys) == 0 {
+				log.Errorf("%q no keys in cfg notification: %+v", txCfg.GetKey().GetJsPath(), txCfg)
+				return
+			}
+			switch txCfg.Op {
+			case ndk.SdkMgrOperation_Create, ndk.SdkMgrOperation_Update:
+				s.handleCfgStaticLabelCreateChange(ctx, txCfg)
+			case ndk.SdkMgrOperation_Delete:
+				s.handleCfgStaticLabelDelete(ctx, txCfg)
+			}
+		case authUserPath:
+			if len(txCfg.Key.Keys) == 0 {
+				log.Errorf("%q no keys in cfg notification: %+v", authUserPath, txCfg)
+				return
+			}
+			switch txCfg.Op {
+			case ndk.SdkMgrOperation_Create, ndk.SdkMgrOperation_Update:
+				s.handleCfgAuthUserCreateChange(ctx, txCfg)
+			case ndk.SdkMgrOperation_Delete:
+				s.handleCfgAuthUserDelete(ctx, txCfg)
+			}
+		case authTokenPath:
+			if len(txCfg.Key.Keys) == 0 {
+				log.Errorf("%q no keys in cfg notification: %+v", authTokenPath, txCfg)
+				return
+			}
+			switch txCfg.Op {
+			case ndk.SdkMgrOperation_Create, ndk.SdkMgrOperation_Update:
+				s.handleCfgAuthTokenCreateChange(ctx, txCfg)
+			case ndk.SdkMgrOperation_Delete:
+				s.handleCfgAuthTokenDelete(ctx, txCfg)
+			}
+		case remoteWriteEndpointPath:
+			if len(txCfg.Key.Keys) == 0 {
+				log.Errorf("%q no keys in cfg notification: %+v", remoteWriteEndpointPath, txCfg)
+				return
+			}
+			switch txCfg.Op {
+			case ndk.SdkMgrOperation_Create, ndk.SdkMgrOperation_Update:
+				s.handleCfgRemoteWriteEndpointCreateChange(ctx, txCfg)
+			case ndk.SdkMgrOperation_Delete:
+				s.handleCfgRemoteWriteEndpointDelete(ctx, txCfg)
+			}
+		case otlpHeaderPath:
+			if len(txCfg.Key.Keys) == 0 {
+				log.Errorf("%q no keys in cfg notification: %+v", otlpHeaderPath, txCfg)
+				return
+			}
+			switch txCfg.Op {
+			case ndk.SdkMgrOperation_Create, ndk.SdkMgrOperation_Update:
+				s.handleCfgOTLPHeaderCreateChange(ctx, txCfg)
+			case ndk.SdkMgrOperation_Delete:
+				s.handleCfgOTLPHeaderDelete(ctx, txCfg)
+			}
+		case scrapeProfilePath:
+			if len(txCfg.Key.Keys) == 0 {
+				log.Errorf("%q no keys in cfg notification: %+v", scrapeProfilePath, txCfg)
+				return
+			}
+			switch txCfg.Op {
+			case ndk.SdkMgrOperation_Create, ndk.SdkMgrOperation_Update:
+				s.handleCfgScrapeProfileCreateChange(ctx, txCfg)
+			case ndk.SdkMgrOperation_Delete:
+				s.handleCfgScrapeProfileDelete(ctx, txCfg)
+			}
 		default:
 			log.Errorf("unexpected config path %q", txCfg.GetKey().GetJsPath())
 		}
@@ -256,8 +513,11 @@ func (s *server) handleCfgPrometheusCreate(ctx context.Context, cfg *ndk.ConfigN
 		log.Debugf("read baseconfig data: %s", string(b))
 	}
 
+	newCfg.RejectedScrapes = s.rejected
+	newCfg.allowedPrefixes = parseAllowedPrefixes(newCfg.AllowedPrefixes)
 	// set default oper state
 	newCfg.OperState = operDown
+	newCfg.GNMIConnectionState = connectivityState(s.gnmi.getState())
 	// store initial config
 	s.config.baseConfig = newCfg
 
@@ -273,12 +533,15 @@ func (s *server) handleCfgPrometheusCreate(ctx context.Context, cfg *ndk.ConfigN
 func (s *server) handleCfgPrometheusChange(ctx context.Context, cfg *ndk.ConfigNotification) {
 	newCfg := &baseConfig{
 		Registration: new(registration),
+		Streaming:    new(streaming),
 	}
 	err := json.Unmarshal([]byte(cfg.GetData().GetJson()), newCfg)
 	if err != nil {
 		log.Errorf("failed to marshal config data from path %q: %v", cfg.GetKey().GetJsPath(), err)
 		return
 	}
+	newCfg.RejectedScrapes = s.rejected
+	newCfg.allowedPrefixes = parseAllowedPrefixes(newCfg.AllowedPrefixes)
 
 	if s.config.debug {
 		b, err := json.MarshalIndent(newCfg, "", "  ")
@@ -297,16 +560,115 @@ func (s *server) handleCfgPrometheusChange(ctx context.Context, cfg *ndk.ConfigN
 	if newCfg.AdminState == adminEnable && s.config.baseConfig.OperState == operDown {
 		// start http server
 		log.Debug("starting server...")
-		s.config.baseConfig.AdminState = adminEnable
+		// store new config, it is used by the starting server
+		newCfg.OperState = s.config.baseConfig.OperState
+		newCfg.GNMIConnectionState = s.config.baseConfig.GNMIConnectionState
+		if s.config.baseConfig.Registration != nil {
+			newCfg.Registration.OperState = s.config.baseConfig.Registration.OperState
+		}
+		s.config.baseConfig = newCfg
 		go s.start(ctx)
 		return
 	}
+	// HTTP server already running, check if the TLS profile changed
+	if s.config.baseConfig.OperState == operUp && s.config.baseConfig.AdminState == adminEnable &&
+		newCfg.TLSProfile.Value != s.config.baseConfig.TLSProfile.Value {
+		if newCfg.TLSProfile.Value == "" || s.config.baseConfig.TLSProfile.Value == "" {
+			// switching between HTTP and HTTPS requires a new listener
+			log.Debug("tls-profile changed, restarting server...")
+			s.shutdown(ctx, time.Second/2)
+			newCfg.OperState = operDown
+			newCfg.Registration.OperState = operDown
+			newCfg.GNMIConnectionState = s.config.baseConfig.GNMIConnectionState
+			s.config.baseConfig = newCfg
+			go s.start(ctx)
+			return
+		}
+		go s.reloadTLSProfile(ctx, s.tlsStore, newCfg.TLSProfile.Value)
+	}
+	// HTTP server already running, check if the streaming subscriptions need to be started, restarted or stopped
+	if s.config.baseConfig.OperState == operUp && s.config.baseConfig.AdminState == adminEnable {
+		oldStreaming := s.config.baseConfig.Streaming
+		if oldStreaming == nil {
+			oldStreaming = new(streaming)
+		}
+		if *newCfg.Streaming != *oldStreaming {
+			s.stopStreaming()
+			if newCfg.Streaming.AdminState == adminEnable {
+				// started after the new config is stored
+				defer func() { go s.startStreaming(ctx) }()
+			}
+		}
+	}
+	// HTTP server already running, check if remote write needs to be started, restarted or stopped
+	if s.config.baseConfig.OperState == operUp && s.config.baseConfig.AdminState == adminEnable {
+		oldRemoteWrite := s.config.baseConfig.RemoteWrite
+		if oldRemoteWrite == nil {
+			oldRemoteWrite = new(remoteWrite)
+		}
+		newRemoteWrite := newCfg.RemoteWrite
+		if newRemoteWrite == nil {
+			newRemoteWrite = new(remoteWrite)
+		}
+		if *newRemoteWrite != *oldRemoteWrite {
+			// restarted after the new config is stored
+			defer s.startRemoteWriteLocked(ctx)
+		}
+	}
+	// HTTP server already running, check if the otlp export needs to be started, restarted or stopped
+	if s.config.baseConfig.OperState == operUp && s.config.baseConfig.AdminState == adminEnable {
+		oldOTLP := s.config.baseConfig.OTLP
+		if oldOTLP == nil {
+			oldOTLP = new(otlp)
+		}
+		newOTLP := newCfg.OTLP
+		if newOTLP == nil {
+			newOTLP = new(otlp)
+		}
+		if *newOTLP != *oldOTLP {
+			// restarted after the new config is stored
+			defer s.startOTLPLocked(ctx)
+		}
+	}
+	// HTTP server already running, check if the pushgateway push needs to be started, restarted or stopped
+	if s.config.baseConfig.OperState == operUp && s.config.baseConfig.AdminState == adminEnable {
+		oldPushgateway := s.config.baseConfig.Pushgateway
+		if oldPushgateway == nil {
+			oldPushgateway = new(pushgateway)
+		}
+		newPushgateway := newCfg.Pushgateway
+		if newPushgateway == nil {
+			newPushgateway = new(pushgateway)
+		}
+		if *newPushgateway != *oldPushgateway {
+			// restarted after the new config is stored
+			defer s.startPushgatewayLocked(ctx)
+		}
+	}
+	// HTTP server already running, check if the identity labels changed
+	if s.config.baseConfig.OperState == operUp && s.config.baseConfig.AdminState == adminEnable {
+		oldIdentityLabels := s.config.baseConfig.IdentityLabels
+		if oldIdentityLabels == nil {
+			oldIdentityLabels = new(identityLabels)
+		}
+		newIdentityLabels := newCfg.IdentityLabels
+		if newIdentityLabels == nil {
+			newIdentityLabels = new(identityLabels)
+		}
+		if *newIdentityLabels != *oldIdentityLabels {
+			// refreshed once the new config is stored and the config lock released
+			s.syncIdentity()
+		}
+	}
 	// HTTP server already running, check if registration has to be started or stopped
 	if s.config.baseConfig.OperState == operUp && s.config.baseConfig.AdminState == adminEnable {
 		log.Debug("server is up, checking if registration needs to be started...")
 		// server is already up, check if registration needs to be started
 		if newCfg.Registration.AdminState == adminEnable && s.config.baseConfig.Registration.OperState == operDown {
 			go s.registerService(ctx)
+		} else if newCfg.Registration.AdminState == adminEnable && registrationChanged(s.config.baseConfig.Registration, newCfg.Registration) {
+			// restarted after the new config is stored
+			defer func() { go s.registerService(ctx) }()
 		} else if newCfg.Registration.AdminState == adminDisable && s.config.baseConfig.OperState != operUp {
 			if s.regCancelFn != nil {
 				s.regCancelFn()
@@ -316,6 +678,7 @@ func (s *server) handleCfgPrometheusChange(ctx context.Context, cfg *ndk.ConfigN
 
 	// save current oper state
 	newCfg.OperState = s.config.baseConfig.OperState
+	newCfg.GNMIConnectionState = s.config.baseConfig.GNMIConnectionState
 	newCfg.Registration.OperState = s.config.baseConfig.Registration.OperState
 	// store new config
 	s.config.baseConfig = newCfg
@@ -344,8 +707,12 @@ func (s *server) handleCfgMetricCreate(ctx context.Context, cfg *ndk.ConfigNotif
 			newMetricConfig.Metric.Paths[i].Value = p
 		}
 	}
+	// keep nested list entries, they are received in separate notifications
+	newMetricConfig.staticLabels = s.config.metrics[key].staticLabels
 	// store new config
 	s.config.metrics[key] = newMetricConfig
+	s.syncSubscription(ctx, key)
+	s.syncRegistration()
 	// update metric telemetry
 	s.updateMetricTelemetry(ctx, key, newMetricConfig)
 }
@@ -361,6 +728,11 @@ func (s *server) handleCfgMetricChange(ctx context.Context, cfg *ndk.ConfigNotif
 
 	// store new config
 	s.config.metrics[key].Metric.State = newMetricConfig.Metric.State
+	s.config.metrics[key].Metric.ScrapeTimeout = newMetricConfig.Metric.ScrapeTimeout
+	s.config.metrics[key].Metric.Include = newMetricConfig.Metric.Include
+	s.config.metrics[key].Metric.Exclude = newMetricConfig.Metric.Exclude
+	s.syncSubscription(ctx, key)
+	s.syncRegistration()
 	// update metric telemetry
 	s.updateMetricTelemetry(ctx, key, newMetricConfig)
 }
@@ -374,6 +746,9 @@ func (s *server) handleCfgMetricDelete(ctx context.Context, cfg *ndk.ConfigNotif
 	}
 	s.config.metrics[key] = &metricConfig{}
 	s.config.metrics[key].Metric.State = stateDisable
+	s.syncSubscription(ctx, key)
+	s.syncRegistration()
+	s.metrics.forget(key)
 	s.deleteMetricTelemetry(ctx, key)
 }
 
@@ -390,9 +765,16 @@ func (s *server) handleCfgCustomMetricCreateChange(ctx context.Context, cfg *ndk
 	if _, ok := s.config.customMetric[key]; !ok {
 		s.config.customMetric[key] = new(customMetricConfig)
 	}
+	// keep nested list entries, they are received in separate notifications
+	newMetricConfig.leafTypes = s.config.customMetric[key].leafTypes
+	newMetricConfig.relabelRules = s.config.customMetric[key].relabelRules
+	newMetricConfig.valueMap = s.config.customMetric[key].valueMap
+	newMetricConfig.staticLabels = s.config.customMetric[key].staticLabels
 
 	// store new config
 	s.config.customMetric[key] = newMetricConfig
+	s.syncSubscription(ctx, key)
+	s.syncRegistration()
 	// update metric telemetry
 	s.updateCustomMetricTelemetry(ctx, key, newMetricConfig)
 }
@@ -405,9 +787,188 @@ func (s *server) handleCfgCustomMetricDelete(ctx context.Context, cfg *ndk.Confi
 		return
 	}
 	delete(s.config.customMetric, key)
+	s.stopSubscription(key)
+	s.syncRegistration()
+	s.metrics.forget(key)
 	s.deleteCustomMetricTelemetry(ctx, key)
 }
 
+func (s *server) handleCfgLeafTypeCreateChange(ctx context.Context, cfg *ndk.ConfigNotification) {
+	key, leaf := cfg.Key.Keys[0], cfg.Key.Keys[1]
+	newLeafTypeConfig := new(leafTypeConfig)
+	err := json.Unmarshal([]byte(cfg.GetData().GetJson()), newLeafTypeConfig)
+	if err != nil {
+		log.Errorf("failed to marshal config data from path %s: %v", cfg.Key.JsPath, err)
+		return
+	}
+	// the custom metric notification might not be handled yet
+	if _, ok := s.config.customMetric[key]; !ok {
+		s.config.customMetric[key] = new(customMetricConfig)
+	}
+	if s.config.customMetric[key].leafTypes == nil {
+		s.config.customMetric[key].leafTypes = make(map[string]string)
+	}
+	s.config.customMetric[key].leafTypes[leaf] = newLeafTypeConfig.LeafType.Type
+	s.updateLeafTypeTelemetry(ctx, key, leaf, newLeafTypeConfig)
+}
+
+func (s *server) handleCfgLeafTypeDelete(ctx context.Context, cfg *ndk.ConfigNotification) {
+	key, leaf := cfg.Key.Keys[0], cfg.Key.Keys[1]
+	if cm, ok := s.config.customMetric[key]; ok {
+		delete(cm.leafTypes, leaf)
+	}
+	s.deleteLeafTypeTelemetry(ctx, key, leaf)
+}
+
+func (s *server) handleCfgRelabelRuleCreateChange(ctx context.Context, cfg *ndk.ConfigNotification) {
+	key := cfg.Key.Keys[0]
+	index, err := strconv.Atoi(cfg.Key.Keys[1])
+	if err != nil {
+		log.Errorf("invalid relabel-rule index %q: %v", cfg.Key.Keys[1], err)
+		return
+	}
+	newRuleConfig := new(relabelRuleConfig)
+	err = json.Unmarshal([]byte(cfg.GetData().GetJson()), newRuleConfig)
+	if err != nil {
+		log.Errorf("failed to marshal config data from path %s: %v", cfg.Key.JsPath, err)
+		return
+	}
+	rule := &relabelRule{
+		Action:      newRuleConfig.RelabelRule.Action,
+		Separator:   newRuleConfig.RelabelRule.Separator.Value,
+		Regex:       newRuleConfig.RelabelRule.Regex.Value,
+		TargetLabel: newRuleConfig.RelabelRule.TargetLabel.Value,
+		Replacement: newRuleConfig.RelabelRule.Replacement.Value,
+		Modulus:     uint64(newRuleConfig.RelabelRule.Modulus.Value),
+	}
+	for _, sl := range newRuleConfig.RelabelRule.SourceLabels {
+		rule.SourceLabels = append(rule.SourceLabels, sl.Value)
+	}
+	err = rule.compile()
+	if err != nil {
+		log.Errorf("custom metric %q: invalid relabel-rule %d: %v", key, index, err)
+		return
+	}
+	// the custom metric notification might not be handled yet
+	if _, ok := s.config.customMetric[key]; !ok {
+		s.config.customMetric[key] = new(customMetricConfig)
+	}
+	if s.config.customMetric[key].relabelRules == nil {
+		s.config.customMetric[key].relabelRules = make(map[int]*relabelRule)
+	}
+	s.config.customMetric[key].relabelRules[index] = rule
+	s.updateRelabelRuleTelemetry(ctx, key, index, newRuleConfig)
+}
+
+func (s *server) handleCfgRelabelRuleDelete(ctx context.Context, cfg *ndk.ConfigNotification) {
+	key := cfg.Key.Keys[0]
+	index, err := strconv.Atoi(cfg.Key.Keys[1])
+	if err != nil {
+		log.Errorf("invalid relabel-rule index %q: %v", cfg.Key.Keys[1], err)
+		return
+	}
+	if cm, ok := s.config.customMetric[key]; ok {
+		delete(cm.relabelRules, index)
+	}
+	s.deleteRelabelRuleTelemetry(ctx, key, index)
+}
+
+func (s *server) handleCfgValueMapCreateChange(ctx context.Context, cfg *ndk.ConfigNotification) {
+	key, value := cfg.Key.Keys[0], cfg.Key.Keys[1]
+	newValueMapConfig := new(valueMapConfig)
+	err := json.Unmarshal([]byte(cfg.GetData().GetJson()), newValueMapConfig)
+	if err != nil {
+		log.Errorf("failed to marshal config data from path %s: %v", cfg.Key.JsPath, err)
+		return
+	}
+	// the custom metric notification might not be handled yet
+	if _, ok := s.config.customMetric[key]; !ok {
+		s.config.customMetric[key] = new(customMetricConfig)
+	}
+	if s.config.customMetric[key].valueMap == nil {
+		s.config.customMetric[key].valueMap = make(map[string]float64)
+	}
+	s.config.customMetric[key].valueMap[value] = float64(newValueMapConfig.ValueMap.Number.Value)
+	s.updateValueMapTelemetry(ctx, key, value, newValueMapConfig)
+}
+
+func (s *server) handleCfgValueMapDelete(ctx context.Context, cfg *ndk.ConfigNotification) {
+	key, value := cfg.Key.Keys[0], cfg.Key.Keys[1]
+	if cm, ok := s.config.customMetric[key]; ok {
+		delete(cm.valueMap, value)
+	}
+	s.deleteValueMapTelemetry(ctx, key, value)
+}
+
+// staticLabels returns the static labels map a static-label config notification applies to,
+// it returns nil if the parent metric is unknown.
+func (s *server) staticLabels(cfg *ndk.ConfigNotification, create bool) map[string]string {
+	key := cfg.Key.Keys[0]
+	switch cfg.Key.JsPath {
+	case metricStaticLabelPath:
+		m, ok := s.config.metrics[key]
+		if !ok {
+			return nil
+		}
+		if m.staticLabels == nil && create {
+			m.staticLabels = make(map[string]string)
+		}
+		return m.staticLabels
+	case customMetricStaticLabelPath:
+		// the custom metric notification might not be handled yet
+		if _, ok := s.config.customMetric[key]; !ok && create {
+			s.config.customMetric[key] = new(customMetricConfig)
+		}
+		m, ok := s.config.customMetric[key]
+		if !ok {
+			return nil
+		}
+		if m.staticLabels == nil && create {
+			m.staticLabels = make(map[string]string)
+		}
+		return m.staticLabels
+	}
+	return s.config.staticLabels
+}
+
+func (s *server) handleCfgStaticLabelCreateChange(ctx context.Context, cfg *ndk.ConfigNotification) {
+	keys := cfg.Key.Keys
+	if cfg.Key.JsPath != staticLabelPath && len(keys) < 2 {
+		log.Errorf("%q missing keys in cfg notification: %+v", cfg.Key.JsPath, cfg)
+		return
+	}
+	newStaticLabelConfig := new(staticLabelConfig)
+	err := json.Unmarshal([]byte(cfg.GetData().GetJson()), newStaticLabelConfig)
+	if err != nil {
+		log.Errorf("failed to marshal config data from path %s: %v", cfg.Key.JsPath, err)
+		return
+	}
+	name := keys[len(keys)-1]
+	if !validLabelName(name) {
+		log.Errorf("static-label %v: invalid label name %q", keys, name)
+		return
+	}
+	lbls := s.staticLabels(cfg, true)
+	if lbls == nil {
+		log.Errorf("static-label %v: unknown metric %q", keys, keys[0])
+		return
+	}
+	lbls[name] = newStaticLabelConfig.StaticLabel.Value.Value
+	s.updateStaticLabelTelemetry(ctx, cfg.Key.JsPath, keys, newStaticLabelConfig)
+}
+
+func (s *server) handleCfgStaticLabelDelete(ctx context.Context, cfg *ndk.ConfigNotification) {
+	keys := cfg.Key.Keys
+	if cfg.Key.JsPath != staticLabelPath && len(keys) < 2 {
+		log.Errorf("%q missing keys in cfg notification: %+v", cfg.Key.JsPath, cfg)
+		return
+	}
+	if lbls := s.staticLabels(cfg, false); lbls != nil {
+		delete(lbls, keys[len(keys)-1])
+	}
+	s.deleteStaticLabelTelemetry(ctx, cfg.Key.JsPath, keys)
+}
+
 func (s *server) handleNwInstCfg(ctx context.Context, nwInst *ndk.NetworkInstanceNotification) {
 	s.config.m.Lock()
 	defer s.config.m.Unlock()
@@ -0,0 +1,28 @@
+package app
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSecretValue(t *testing.T) {
+	cfg := new(pushgateway)
+	err := json.Unmarshal([]byte(`{"url":{"value":"http://pgw:9091"},"password":{"value":"s3cr3t"}}`), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Password.Value != "s3cr3t" {
+		t.Fatalf("got password %q, expected the configured one", cfg.Password.Value)
+	}
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "s3cr3t") {
+		t.Errorf("secret leaked in marshaled config: %s", b)
+	}
+	if !strings.Contains(string(b), `"url":{"value":"http://pgw:9091"}`) {
+		t.Errorf("expected the url in marshaled config: %s", b)
+	}
+}
@@ -0,0 +1,258 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	capi "github.com/hashicorp/consul/api"
+	"github.com/nokia/srlinux-ndk-go/ndk"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netns"
+)
+
+const (
+	// YANG enums are received as SCHEME_<value>
+	consulSchemeHTTPS = "SCHEME_https"
+)
+
+// consulConfig holds the Consul specific registration settings.
+type consulConfig struct {
+	Scheme            string        `json:"scheme,omitempty"`
+	CACertificate     stringValue   `json:"ca_certificate,omitempty"`
+	TLSProfile        stringValue   `json:"tls_profile,omitempty"`
+	ClientCertificate stringValue   `json:"client_certificate,omitempty"`
+	ClientKey         secretValue   `json:"client_key,omitempty"`
+	SkipVerify        boolValue     `json:"skip_verify,omitempty"`
+	Datacenter        stringValue   `json:"datacenter,omitempty"`
+	Namespace         stringValue   `json:"namespace,omitempty"`
+	Partition         stringValue   `json:"partition,omitempty"`
+	FallbackAddress   []stringValue `json:"fallback_address,omitempty"`
+	CheckName         stringValue   `json:"check_name,omitempty"`
+	CheckNotes        stringValue   `json:"check_notes,omitempty"`
+	ServiceMode       string        `json:"service_mode,omitempty"`
+}
+
+// scrapeProfileConfig is a set of metric groups registered as a single Consul service.
+type scrapeProfileConfig struct {
+	ScrapeProfile struct {
+		Metric []stringValue `json:"metric,omitempty"`
+	} `json:"scrape_profile,omitempty"`
+}
+
+// consulRegistrar registers the exporter as a Consul service with a TTL check.
+type consulRegistrar struct {
+	cfg    *registration
+	client *capi.Client
+	// address of the server the client is connected to
+	addr string
+}
+
+// consulAddresses returns the configured address then the fallback addresses in order,
+// starting with the address following failed if it is one of them.
+func consulAddresses(cfg *registration, consulCfg *consulConfig, failed string) []string {
+	addresses := make([]string, 0, len(consulCfg.FallbackAddress)+1)
+	addresses = append(addresses, cfg.Address.Value)
+	for _, addr := range consulCfg.FallbackAddress {
+		addresses = append(addresses, addr.Value)
+	}
+	for i, addr := range addresses {
+		if addr == failed {
+			return append(addresses[i+1:], addresses[:i+1]...)
+		}
+	}
+	return addresses
+}
+
+// newConsulRegistrar returns a registrar connected to the first reachable server,
+// the configured address then the fallback addresses in order, the server failed is tried last.
+// The CA and client certificates of tls-profile tp, if any, take precedence over the configured ones.
+func newConsulRegistrar(cfg *registration, tp *tlsProfile, n netns.NsHandle, failed string) (*consulRegistrar, error) {
+	consulCfg := cfg.Consul
+	if consulCfg == nil {
+		consulCfg = new(consulConfig)
+	}
+	var errs []error
+	for _, addr := range consulAddresses(cfg, consulCfg, failed) {
+		client, err := newConsulClient(cfg, consulCfg, tp, addr, n)
+		if err != nil {
+			log.Errorf("consul server %q: %v", addr, err)
+			errs = append(errs, fmt.Errorf("%q: %v", addr, err))
+			continue
+		}
+		log.Infof("using consul server %q", addr)
+		return &consulRegistrar{cfg: cfg, client: client, addr: addr}, nil
+	}
+	return nil, fmt.Errorf("no reachable consul server: %v", errors.Join(errs...))
+}
+
+func newConsulClient(cfg *registration, consulCfg *consulConfig, tp *tlsProfile, addr string, n netns.NsHandle) (*capi.Client, error) {
+	clientConfig := &capi.Config{
+		Address:    addr,
+		Scheme:     "http",
+		Datacenter: consulCfg.Datacenter.Value,
+		Namespace:  consulCfg.Namespace.Value,
+		Partition:  consulCfg.Partition.Value,
+		Token:      cfg.Token.Value,
+		Transport: &http.Transport{
+			DialContext: netnsDialContext(n),
+		},
+	}
+	if consulCfg.Scheme == consulSchemeHTTPS {
+		clientConfig.Scheme = "https"
+		clientConfig.TLSConfig = capi.TLSConfig{
+			Address:            addr,
+			CAPem:              []byte(consulCfg.CACertificate.Value),
+			CertPEM:            []byte(consulCfg.ClientCertificate.Value),
+			KeyPEM:             []byte(consulCfg.ClientKey.Value),
+			InsecureSkipVerify: consulCfg.SkipVerify.Value,
+		}
+		if tp != nil {
+			if tp.TrustAnchor != "" {
+				clientConfig.TLSConfig.CAPem = []byte(tp.TrustAnchor)
+			}
+			clientConfig.TLSConfig.CertPEM = []byte(tp.Certificate)
+			clientConfig.TLSConfig.KeyPEM = []byte(tp.Key)
+		}
+	}
+	if cfg.Username.Value != "" && cfg.Password.Value != "" {
+		clientConfig.HttpAuth = &capi.HttpBasicAuth{
+			Username: cfg.Username.Value,
+			Password: cfg.Password.Value,
+		}
+	}
+	client, err := capi.NewClient(clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Consul client: %v", err)
+	}
+	self, err := client.Agent().Self()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Consul Agent details: %v", err)
+	}
+	if cfg, ok := self["Config"]; ok {
+		b, _ := json.Marshal(cfg)
+		log.Infof("consul agent config: %s", string(b))
+	}
+	return client, nil
+}
+
+// ttlCheckID returns the ID of the TTL check of service svc,
+// it is critical and the service deregistered if the exporter stops updating it.
+func ttlCheckID(svc *serviceInstance) string {
+	return "service:" + svc.ID
+}
+
+// healthCheckID returns the ID of the TTL check reporting the exporter health,
+// the service is not deregistered while it is critical.
+func healthCheckID(svc *serviceInstance) string {
+	return "service:" + svc.ID + ":health"
+}
+
+func (r *consulRegistrar) register(ctx context.Context, svc *serviceInstance) error {
+	tags := make([]string, 0, len(svc.Tags)+6)
+	tags = append(tags, svc.Tags...)
+	tags = append(tags,
+		fmt.Sprintf("version=%s", svc.sysInfo.Version),
+		fmt.Sprintf("chassis-type=%s", svc.sysInfo.ChassisType),
+		fmt.Sprintf("chassis-mac-address=%s", svc.sysInfo.ChassisMacAddress),
+		fmt.Sprintf("chassis-part-number=%s", svc.sysInfo.ChassisPartNumber),
+		fmt.Sprintf("chassis-serial-number=%s", svc.sysInfo.ChassisSerialNumber),
+		fmt.Sprintf("chassis-clei-code=%s", svc.sysInfo.ChassisCLEICode),
+	)
+
+	var checkName, checkNotes string
+	if r.cfg.Consul != nil {
+		checkName = r.cfg.Consul.CheckName.Value
+		checkNotes = r.cfg.Consul.CheckNotes.Value
+	}
+	service := &capi.AgentServiceRegistration{
+		ID:      svc.ID,
+		Name:    svc.Name,
+		Address: svc.Address,
+		Port:    svc.Port,
+		Tags:    tags,
+		Meta:    svc.Meta,
+		Checks: capi.AgentServiceChecks{
+			{
+				CheckID:                        ttlCheckID(svc),
+				Name:                           checkName,
+				Notes:                          checkNotes,
+				TTL:                            r.cfg.TTL.Value,
+				DeregisterCriticalServiceAfter: r.cfg.TTL.Value,
+			},
+			{
+				CheckID: healthCheckID(svc),
+				Name:    "Exporter health",
+				Notes:   "HTTP listener, gNMI connection and metrics collection health",
+				TTL:     r.cfg.TTL.Value,
+			},
+		},
+	}
+	switch {
+	case r.cfg.HTTPCheck.Value && svc.clientAuth:
+		// the Consul agent does not present a client certificate
+		log.Warnf("service %q: skipping the HTTP check, the tls-profile requires a client certificate", svc.ID)
+	case r.cfg.HTTPCheck.Value:
+		service.Checks = append(service.Checks, &capi.AgentServiceCheck{
+			HTTP:                           fmt.Sprintf("%s://%s", svc.Scheme, svc.target()),
+			Method:                         "GET",
+			Interval:                       r.cfg.TTL.Value,
+			TLSSkipVerify:                  true,
+			DeregisterCriticalServiceAfter: r.cfg.TTL.Value,
+		})
+	}
+	b, _ := json.Marshal(service)
+	log.Infof("registering service: %s", string(b))
+	err := r.client.Agent().ServiceRegisterOpts(service, capi.ServiceRegisterOpts{}.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to register service in consul: %v", err)
+	}
+	return nil
+}
+
+// keepalive passes the TTL check and updates the health check with the exporter health.
+func (r *consulRegistrar) keepalive(ctx context.Context, svc *serviceInstance, h *exporterHealth) error {
+	q := (&capi.QueryOptions{}).WithContext(ctx)
+	err := r.client.Agent().UpdateTTLOpts(ttlCheckID(svc), "", capi.HealthPassing, q)
+	if err != nil {
+		return err
+	}
+	status := capi.HealthPassing
+	switch h.status {
+	case healthWarning:
+		status = capi.HealthWarning
+	case healthCritical:
+		status = capi.HealthCritical
+	}
+	return r.client.Agent().UpdateTTLOpts(healthCheckID(svc), h.output, status, q)
+}
+
+func (r *consulRegistrar) deregister(ctx context.Context, svc *serviceInstance) error {
+	return r.client.Agent().ServiceDeregisterOpts(svc.ID, (&capi.QueryOptions{}).WithContext(ctx))
+}
+
+func (r *consulRegistrar) close() error {
+	return nil
+}
+
+func (s *server) handleCfgScrapeProfileCreateChange(ctx context.Context, cfg *ndk.ConfigNotification) {
+	name := cfg.Key.Keys[0]
+	newProfileConfig := new(scrapeProfileConfig)
+	err := json.Unmarshal([]byte(cfg.GetData().GetJson()), newProfileConfig)
+	if err != nil {
+		log.Errorf("failed to marshal config data from path %s: %v", cfg.Key.JsPath, err)
+		return
+	}
+	s.config.scrapeProfiles[name] = newProfileConfig
+	s.updateScrapeProfileTelemetry(ctx, name, newProfileConfig)
+	s.syncRegistration()
+}
+
+func (s *server) handleCfgScrapeProfileDelete(ctx context.Context, cfg *ndk.ConfigNotification) {
+	name := cfg.Key.Keys[0]
+	delete(s.config.scrapeProfiles, name)
+	s.deleteScrapeProfileTelemetry(ctx, name)
+	s.syncRegistration()
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vishvananda/netns"
+)
+
+func testConsulRegistration(addr string, fallback ...string) *registration {
+	cfg := &registration{
+		Backend: registrationBackendConsul,
+		Address: stringValue{Value: addr},
+		Consul:  new(consulConfig),
+	}
+	for _, f := range fallback {
+		cfg.Consul.FallbackAddress = append(cfg.Consul.FallbackAddress, stringValue{Value: f})
+	}
+	return cfg
+}
+
+func TestConsulAddresses(t *testing.T) {
+	cfg := testConsulRegistration("a:8500", "b:8500", "c:8500")
+	tests := []struct {
+		failed string
+		want   []string
+	}{
+		{failed: "", want: []string{"a:8500", "b:8500", "c:8500"}},
+		{failed: "a:8500", want: []string{"b:8500", "c:8500", "a:8500"}},
+		{failed: "b:8500", want: []string{"c:8500", "a:8500", "b:8500"}},
+		{failed: "c:8500", want: []string{"a:8500", "b:8500", "c:8500"}},
+		{failed: "unknown:8500", want: []string{"a:8500", "b:8500", "c:8500"}},
+	}
+	for _, tt := range tests {
+		got := consulAddresses(cfg, cfg.Consul, tt.failed)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("failed %q: got %v, expected %v", tt.failed, got, tt.want)
+		}
+	}
+}
+
+// newTestConsulAgent returns a Consul agent API stub, unhealthy agents fail the agent self request.
+func newTestConsulAgent(t *testing.T, healthy bool) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy || r.URL.Path != "/v1/agent/self" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Config":{"NodeName":"test"}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestNewConsulRegistrarFailover(t *testing.T) {
+	down := newTestConsulAgent(t, false)
+	up1 := newTestConsulAgent(t, true)
+	up2 := newTestConsulAgent(t, true)
+	cfg := testConsulRegistration(down, up1, up2)
+
+	tests := []struct {
+		name   string
+		failed string
+		want   string
+	}{
+		{name: "first reachable server", failed: "", want: up1},
+		{name: "server following the failed one", failed: up1, want: up2},
+		{name: "wrap around, skipping unreachable servers", failed: up2, want: up1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg, err := newConsulRegistrar(cfg, nil, netns.None(), tt.failed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reg.addr != tt.want {
+				t.Errorf("connected to %q, expected %q", reg.addr, tt.want)
+			}
+		})
+	}
+
+	_, err := newConsulRegistrar(testConsulRegistration(down), nil, netns.None(), "")
+	if err == nil {
+		t.Error("expected an error without reachable server")
+	}
+}
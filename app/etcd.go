@@ -0,0 +1,122 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/vishvananda/netns"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+)
+
+const (
+	defaultEtcdKeyPrefix = "/services"
+	etcdDialTimeout      = 5 * time.Second
+)
+
+// etcdRegistrar registers each service as a key attached to its own etcd v3 lease,
+// the key is removed by etcd if the lease is not renewed within the TTL.
+// The key is <key-prefix>/<service name>/<service ID>, its value is the JSON encoded service instance.
+type etcdRegistrar struct {
+	client *clientv3.Client
+	prefix string
+	ttl    int64
+	// leases per service ID
+	leases map[string]clientv3.LeaseID
+}
+
+func newEtcdRegistrar(cfg *registration, ttl time.Duration, n netns.NsHandle) (*etcdRegistrar, error) {
+	var endpoints []string
+	for _, ep := range strings.Split(cfg.Address.Value, ",") {
+		if ep = strings.TrimSpace(ep); ep != "" {
+			endpoints = append(endpoints, ep)
+		}
+	}
+	if len(endpoints) == 0 {
+		return nil, errors.New("missing etcd endpoints address")
+	}
+	dial := netnsDialContext(n)
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		Username:    cfg.Username.Value,
+		Password:    cfg.Password.Value,
+		DialTimeout: etcdDialTimeout,
+		DialOptions: []grpc.DialOption{
+			grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+				return dial(ctx, "tcp", addr)
+			}),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create etcd client: %v", err)
+	}
+	prefix := cfg.KeyPrefix.Value
+	if prefix == "" {
+		prefix = defaultEtcdKeyPrefix
+	}
+	return &etcdRegistrar{
+		client: client,
+		prefix: prefix,
+		// etcd lease TTLs are in seconds
+		ttl:    int64(math.Max(1, math.Ceil(ttl.Seconds()))),
+		leases: make(map[string]clientv3.LeaseID),
+	}, nil
+}
+
+func (r *etcdRegistrar) key(svc *serviceInstance) string {
+	return path.Join(r.prefix, svc.Name, svc.ID)
+}
+
+func (r *etcdRegistrar) register(ctx context.Context, svc *serviceInstance) error {
+	b, err := json.Marshal(svc)
+	if err != nil {
+		return err
+	}
+	lease, err := r.client.Grant(ctx, r.ttl)
+	if err != nil {
+		return fmt.Errorf("failed to grant lease: %v", err)
+	}
+	_, err = r.client.Put(ctx, r.key(svc), string(b), clientv3.WithLease(lease.ID))
+	if err != nil {
+		r.client.Revoke(ctx, lease.ID)
+		return fmt.Errorf("failed to put key %q: %v", r.key(svc), err)
+	}
+	r.leases[svc.ID] = lease.ID
+	return nil
+}
+
+func (r *etcdRegistrar) keepalive(ctx context.Context, svc *serviceInstance, _ *exporterHealth) error {
+	leaseID, ok := r.leases[svc.ID]
+	if !ok {
+		return r.register(ctx, svc)
+	}
+	_, err := r.client.KeepAliveOnce(ctx, leaseID)
+	if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		// the lease expired, the key was removed
+		return r.register(ctx, svc)
+	}
+	return err
+}
+
+func (r *etcdRegistrar) deregister(ctx context.Context, svc *serviceInstance) error {
+	leaseID, ok := r.leases[svc.ID]
+	if !ok {
+		return nil
+	}
+	delete(r.leases, svc.ID)
+	// revoking the lease deletes the key
+	_, err := r.client.Revoke(ctx, leaseID)
+	return err
+}
+
+func (r *etcdRegistrar) close() error {
+	return r.client.Close()
+}
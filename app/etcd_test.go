@@ -0,0 +1,162 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// fakeEtcd implements the etcd KV and Lease calls made by the registrar.
+type fakeEtcd struct {
+	clientv3.KV
+	clientv3.Lease
+
+	lastID clientv3.LeaseID
+	// key attached to each live lease
+	leases map[clientv3.LeaseID]string
+	values map[string]string
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{
+		leases: make(map[clientv3.LeaseID]string),
+		values: make(map[string]string),
+	}
+}
+
+func (f *fakeEtcd) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	f.lastID++
+	f.leases[f.lastID] = ""
+	return &clientv3.LeaseGrantResponse{ID: f.lastID, TTL: ttl}, nil
+}
+
+// Put attaches the key to the last granted lease, the registrar puts right after the grant.
+func (f *fakeEtcd) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	f.leases[f.lastID] = key
+	f.values[key] = val
+	return &clientv3.PutResponse{}, nil
+}
+
+func (f *fakeEtcd) KeepAliveOnce(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseKeepAliveResponse, error) {
+	if _, ok := f.leases[id]; !ok {
+		return nil, rpctypes.ErrLeaseNotFound
+	}
+	return &clientv3.LeaseKeepAliveResponse{ID: id}, nil
+}
+
+func (f *fakeEtcd) Revoke(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error) {
+	f.expire(id)
+	return &clientv3.LeaseRevokeResponse{}, nil
+}
+
+// expire removes the lease and its key.
+func (f *fakeEtcd) expire(id clientv3.LeaseID) {
+	if key, ok := f.leases[id]; ok {
+		delete(f.values, key)
+		delete(f.leases, id)
+	}
+}
+
+func TestEtcdRegistrar(t *testing.T) {
+	svc1 := testServiceInstance("srl1-interfaces", 9804)
+	svc2 := testServiceInstance("srl1-bgp", 9805)
+
+	type step struct {
+		action string
+		svc    *serviceInstance
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		// expected keys and their service ID
+		want map[string]string
+	}{
+		{
+			name:  "register",
+			steps: []step{{"register", svc1}},
+			want:  map[string]string{"/services/srl-prometheus-exporter/srl1-interfaces": "srl1-interfaces"},
+		},
+		{
+			name:  "register several services",
+			steps: []step{{"register", svc1}, {"register", svc2}, {"keepalive", svc1}, {"keepalive", svc2}},
+			want: map[string]string{
+				"/services/srl-prometheus-exporter/srl1-interfaces": "srl1-interfaces",
+				"/services/srl-prometheus-exporter/srl1-bgp":        "srl1-bgp",
+			},
+		},
+		{
+			name:  "deregister one of the services",
+			steps: []step{{"register", svc1}, {"register", svc2}, {"deregister", svc1}, {"keepalive", svc2}},
+			want:  map[string]string{"/services/srl-prometheus-exporter/srl1-bgp": "srl1-bgp"},
+		},
+		{
+			name:  "deregister all the services",
+			steps: []step{{"register", svc1}, {"register", svc2}, {"deregister", svc2}, {"deregister", svc1}},
+			want:  map[string]string{},
+		},
+		{
+			name:  "deregister an unregistered service",
+			steps: []step{{"register", svc1}, {"deregister", svc2}},
+			want:  map[string]string{"/services/srl-prometheus-exporter/srl1-interfaces": "srl1-interfaces"},
+		},
+		{
+			name:  "keepalive registers again after the lease expired",
+			steps: []step{{"register", svc1}, {"register", svc2}, {"expire", svc1}, {"keepalive", svc1}, {"keepalive", svc2}},
+			want: map[string]string{
+				"/services/srl-prometheus-exporter/srl1-interfaces": "srl1-interfaces",
+				"/services/srl-prometheus-exporter/srl1-bgp":        "srl1-bgp",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fake := newFakeEtcd()
+			r := &etcdRegistrar{
+				client: &clientv3.Client{KV: fake, Lease: fake},
+				prefix: defaultEtcdKeyPrefix,
+				ttl:    10,
+				leases: make(map[string]clientv3.LeaseID),
+			}
+			var err error
+			for i, st := range tt.steps {
+				switch st.action {
+				case "register":
+					err = r.register(ctx, st.svc)
+				case "keepalive":
+					err = r.keepalive(ctx, st.svc, nil)
+				case "deregister":
+					err = r.deregister(ctx, st.svc)
+				case "expire":
+					fake.expire(r.leases[st.svc.ID])
+				}
+				if err != nil {
+					t.Fatalf("step %d %s: %v", i, st.action, err)
+				}
+			}
+			if len(fake.values) != len(tt.want) {
+				t.Fatalf("got keys %v, expected %v", fake.values, tt.want)
+			}
+			for key, id := range tt.want {
+				v, ok := fake.values[key]
+				if !ok {
+					t.Fatalf("missing key %q, got %v", key, fake.values)
+				}
+				svc := new(serviceInstance)
+				if err := json.Unmarshal([]byte(v), svc); err != nil {
+					t.Fatalf("key %q: invalid value: %v", key, err)
+				}
+				if svc.ID != id {
+					t.Errorf("key %q: got service ID %q, expected %q", key, svc.ID, id)
+				}
+			}
+			// each service keeps its own lease
+			if len(fake.leases) != len(tt.want) {
+				t.Errorf("got %d live leases, expected %d", len(fake.leases), len(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const defaultFileSDPath = "/etc/opt/srlinux/prometheus-exporter/file_sd.json"
+
+// fileSDTargetGroup is a Prometheus file_sd_configs target group.
+type fileSDTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// fileSDRegistrar writes the exporter targets to a Prometheus file_sd JSON file,
+// e.g on a mounted path read by Prometheus, one target group per registered service.
+// The file is removed when the last service is deregistered.
+type fileSDRegistrar struct {
+	path string
+	// target groups per service ID
+	groups map[string]*fileSDTargetGroup
+}
+
+func newFileSDRegistrar(cfg *registration) (*fileSDRegistrar, error) {
+	p := cfg.FilePath.Value
+	if p == "" {
+		p = defaultFileSDPath
+	}
+	return &fileSDRegistrar{
+		path:   p,
+		groups: make(map[string]*fileSDTargetGroup),
+	}, nil
+}
+
+func (r *fileSDRegistrar) register(ctx context.Context, svc *serviceInstance) error {
+	lbls := map[string]string{
+		"__scheme__":            svc.Scheme,
+		"__metrics_path__":      svc.Meta["metrics_path"],
+		"host_name":             svc.sysInfo.Name,
+		"software_version":      svc.sysInfo.Version,
+		"chassis_type":          svc.sysInfo.ChassisType,
+		"chassis_mac_address":   svc.sysInfo.ChassisMacAddress,
+		"chassis_part_number":   svc.sysInfo.ChassisPartNumber,
+		"chassis_serial_number": svc.sysInfo.ChassisSerialNumber,
+	}
+	if len(svc.Tags) > 0 {
+		// same format as __meta_consul_tags
+		lbls["__meta_srl_tags"] = "," + strings.Join(svc.Tags, ",") + ","
+	}
+	for k, v := range lbls {
+		if v == "" {
+			delete(lbls, k)
+		}
+	}
+	r.groups[svc.ID] = &fileSDTargetGroup{
+		Targets: []string{svc.target()},
+		Labels:  lbls,
+	}
+	return r.write()
+}
+
+// write replaces the file with the registered target groups, ordered by service ID.
+func (r *fileSDRegistrar) write() error {
+	ids := make([]string, 0, len(r.groups))
+	for id := range r.groups {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	tgs := make([]*fileSDTargetGroup, 0, len(ids))
+	for _, id := range ids {
+		tgs = append(tgs, r.groups[id])
+	}
+	b, err := json.MarshalIndent(tgs, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(r.path), 0755)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file and rename it,
+	// so that Prometheus never reads a partially written file
+	f, err := os.CreateTemp(filepath.Dir(r.path), "."+filepath.Base(r.path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), r.path)
+}
+
+// keepalive rewrites the file if it was removed.
+func (r *fileSDRegistrar) keepalive(ctx context.Context, svc *serviceInstance, _ *exporterHealth) error {
+	_, err := os.Stat(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r.register(ctx, svc)
+	}
+	return err
+}
+
+// deregister removes the service target group, and the file if it was the last one.
+func (r *fileSDRegistrar) deregister(ctx context.Context, svc *serviceInstance) error {
+	delete(r.groups, svc.ID)
+	if len(r.groups) > 0 {
+		return r.write()
+	}
+	err := os.Remove(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (r *fileSDRegistrar) close() error {
+	return nil
+}
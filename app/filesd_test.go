@@ -0,0 +1,168 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testServiceInstance(id string, port int, tags ...string) *serviceInstance {
+	return &serviceInstance{
+		ID:      id,
+		Name:    "srl-prometheus-exporter",
+		Address: "10.1.1.1",
+		Port:    port,
+		Scheme:  "http",
+		Tags:    tags,
+		Meta:    map[string]string{"metrics_path": "/metrics"},
+		sysInfo: &systemInfo{Name: "srl1", Version: "v23.10.1"},
+	}
+}
+
+func TestFileSDRegistrar(t *testing.T) {
+	svc1 := testServiceInstance("srl1-interfaces", 9804, "dc1")
+	svc2 := testServiceInstance("srl1-bgp", 9805)
+
+	type step struct {
+		action string
+		svc    *serviceInstance
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		// expected target groups in the file, nil if the file must not exist
+		want []*fileSDTargetGroup
+	}{
+		{
+			name:  "register",
+			steps: []step{{"register", svc1}},
+			want: []*fileSDTargetGroup{
+				{
+					Targets: []string{"10.1.1.1:9804"},
+					Labels: map[string]string{
+						"__scheme__":       "http",
+						"__metrics_path__": "/metrics",
+						"host_name":        "srl1",
+						"software_version": "v23.10.1",
+						"__meta_srl_tags":  ",dc1,",
+					},
+				},
+			},
+		},
+		{
+			name:  "register several services",
+			steps: []step{{"register", svc1}, {"register", svc2}},
+			want: []*fileSDTargetGroup{
+				{Targets: []string{"10.1.1.1:9805"}},
+				{Targets: []string{"10.1.1.1:9804"}},
+			},
+		},
+		{
+			name:  "register again replaces the service target group",
+			steps: []step{{"register", svc1}, {"register", testServiceInstance("srl1-interfaces", 9900)}},
+			want:  []*fileSDTargetGroup{{Targets: []string{"10.1.1.1:9900"}}},
+		},
+		{
+			name:  "deregister one of the services",
+			steps: []step{{"register", svc1}, {"register", svc2}, {"deregister", svc1}},
+			want:  []*fileSDTargetGroup{{Targets: []string{"10.1.1.1:9805"}}},
+		},
+		{
+			name:  "deregister the last service removes the file",
+			steps: []step{{"register", svc1}, {"register", svc2}, {"deregister", svc1}, {"deregister", svc2}},
+		},
+		{
+			name:  "deregister a missing file",
+			steps: []step{{"register", svc1}, {"remove", nil}, {"deregister", svc1}},
+		},
+		{
+			name:  "keepalive rewrites a removed file",
+			steps: []step{{"register", svc1}, {"register", svc2}, {"remove", nil}, {"keepalive", svc1}},
+			want: []*fileSDTargetGroup{
+				{Targets: []string{"10.1.1.1:9805"}},
+				{Targets: []string{"10.1.1.1:9804"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			cfg := new(registration)
+			// the parent directories are created
+			cfg.FilePath.Value = filepath.Join(dir, "targets", "file_sd.json")
+			r, err := newFileSDRegistrar(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, st := range tt.steps {
+				switch st.action {
+				case "register":
+					err = r.register(ctx, st.svc)
+				case "keepalive":
+					err = r.keepalive(ctx, st.svc, nil)
+				case "deregister":
+					err = r.deregister(ctx, st.svc)
+				case "remove":
+					err = os.Remove(cfg.FilePath.Value)
+				}
+				if err != nil {
+					t.Fatalf("step %d %s: %v", i, st.action, err)
+				}
+			}
+
+			// no temporary file is left behind
+			entries, err := os.ReadDir(filepath.Dir(cfg.FilePath.Value))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if e.Name() != filepath.Base(cfg.FilePath.Value) {
+					t.Errorf("unexpected file %q", e.Name())
+				}
+			}
+
+			b, err := os.ReadFile(cfg.FilePath.Value)
+			if tt.want == nil {
+				if !os.IsNotExist(err) {
+					t.Fatalf("expected the file to be removed, got err=%v: %s", err, b)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			fi, err := os.Stat(cfg.FilePath.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0644 {
+				t.Errorf("got file mode %v, expected 0644", fi.Mode().Perm())
+			}
+			var got []*fileSDTargetGroup
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatalf("invalid file_sd file: %v: %s", err, b)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d target groups, expected %d: %s", len(got), len(tt.want), b)
+			}
+			for i := range tt.want {
+				if len(got[i].Targets) != 1 || got[i].Targets[0] != tt.want[i].Targets[0] {
+					t.Errorf("target group %d: got targets %v, expected %v", i, got[i].Targets, tt.want[i].Targets)
+				}
+				for k, v := range tt.want[i].Labels {
+					if got[i].Labels[k] != v {
+						t.Errorf("target group %d: got label %s=%q, expected %q", i, k, got[i].Labels[k], v)
+					}
+				}
+				for k, v := range got[i].Labels {
+					if v == "" {
+						t.Errorf("target group %d: unexpected empty label %s", i, k)
+					}
+				}
+			}
+		})
+	}
+}
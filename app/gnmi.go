@@ -0,0 +1,139 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	gnmiMinConnectTimeout = 2 * time.Second
+	gnmiMaxBackoffDelay   = 30 * time.Second
+)
+
+// gnmiConn is the long-lived gRPC connection to the local gNMI server,
+// shared by collection, system-info lookups and registration.
+type gnmiConn struct {
+	m      *sync.RWMutex
+	conn   *grpc.ClientConn
+	client gnmi.GNMIClient
+	state  connectivity.State
+	// called on each connectivity state change
+	onStateChange func(context.Context, connectivity.State)
+}
+
+func newGNMIConn(onStateChange func(context.Context, connectivity.State)) *gnmiConn {
+	return &gnmiConn{
+		m:             new(sync.RWMutex),
+		state:         connectivity.Shutdown,
+		onStateChange: onStateChange,
+	}
+}
+
+// start creates the gRPC connection and monitors its state until ctx is done.
+// The connection is not blocking, RPCs wait for it to be ready until their context deadline.
+func (g *gnmiConn) start(ctx context.Context) error {
+	conn, err := grpc.Dial(gnmiServerUnixSocket,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.WaitForReady(true)),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff: backoff.Config{
+				BaseDelay:  time.Second,
+				Multiplier: 1.6,
+				Jitter:     0.2,
+				MaxDelay:   gnmiMaxBackoffDelay,
+			},
+			MinConnectTimeout: gnmiMinConnectTimeout,
+		}),
+	)
+	if err != nil {
+		return err
+	}
+	g.m.Lock()
+	g.conn = conn
+	g.client = gnmi.NewGNMIClient(conn)
+	g.m.Unlock()
+
+	conn.Connect()
+	go g.monitor(ctx)
+	return nil
+}
+
+func (g *gnmiConn) monitor(ctx context.Context) {
+	defer g.conn.Close()
+	for {
+		st := g.conn.GetState()
+		g.setState(ctx, st)
+		switch st {
+		case connectivity.Idle:
+			g.conn.Connect()
+		case connectivity.TransientFailure:
+			log.Warnf("gnmi connection to %q in state %s, reconnecting...", gnmiServerUnixSocket, st)
+		}
+		if !g.conn.WaitForStateChange(ctx, st) {
+			// ctx is done
+			g.setState(ctx, connectivity.Shutdown)
+			return
+		}
+	}
+}
+
+func (g *gnmiConn) setState(ctx context.Context, st connectivity.State) {
+	g.m.Lock()
+	changed := g.state != st
+	g.state = st
+	g.m.Unlock()
+	if !changed {
+		return
+	}
+	log.Infof("gnmi connection to %q state: %s", gnmiServerUnixSocket, st)
+	if g.onStateChange != nil {
+		g.onStateChange(ctx, st)
+	}
+}
+
+func (g *gnmiConn) getState() connectivity.State {
+	g.m.RLock()
+	defer g.m.RUnlock()
+	return g.state
+}
+
+// gnmiClient returns the shared gNMI client once the connection is ready,
+// it waits up to gnmiMinConnectTimeout for the connection to become ready.
+func (g *gnmiConn) gnmiClient(ctx context.Context) (gnmi.GNMIClient, error) {
+	g.m.RLock()
+	conn, client := g.conn, g.client
+	g.m.RUnlock()
+	if client == nil {
+		return nil, errors.New("gnmi connection not started")
+	}
+	ctx, cancel := context.WithTimeout(ctx, gnmiMinConnectTimeout)
+	defer cancel()
+	for {
+		st := conn.GetState()
+		switch st {
+		case connectivity.Ready:
+			return client, nil
+		case connectivity.Shutdown:
+			return nil, errors.New("gnmi connection is shutdown")
+		}
+		if !conn.WaitForStateChange(ctx, st) {
+			return nil, fmt.Errorf("gnmi connection not ready: %s", st)
+		}
+	}
+}
+
+// connectivityState returns the YANG representation of a connectivity state.
+func connectivityState(st connectivity.State) string {
+	return "GNMI_CONNECTION_STATE_" + strings.ToLower(st.String())
+}
@@ -0,0 +1,154 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	collectParam = "collect[]"
+	// comma separated metric names, can be set from Prometheus relabeling with __param_collect
+	collectListParam = "collect"
+	matchParam       = "match[]"
+)
+
+var errForbiddenMetric = errors.New("metric not allowed for this client")
+
+// scrapeFilter selects the metrics collected by a scrape request.
+type scrapeFilter struct {
+	// metric names from the collect[] query parameters
+	// and the client allowed metrics, all metrics if nil
+	groups map[string]struct{}
+	// series selectors from the match[] query parameters
+	match []selector
+}
+
+// metrics returns the metrics selected by the filter,
+// all metrics if the filter is nil or has no groups.
+func (f *scrapeFilter) metrics(metrics map[string]metric) map[string]metric {
+	if f == nil || f.groups == nil {
+		return metrics
+	}
+	selected := make(map[string]metric, len(f.groups))
+	for name, m := range metrics {
+		if _, ok := f.groups[name]; ok {
+			selected[name] = m
+		}
+	}
+	return selected
+}
+
+// filteredCollector collects the metrics selected by a scrape filter.
+type filteredCollector struct {
+	s      *server
+	filter *scrapeFilter
+}
+
+// Describe implements prometheus.Collector
+func (fc *filteredCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector
+func (fc *filteredCollector) Collect(ch chan<- prometheus.Metric) {
+	fc.s.collect(ch, fc.filter)
+}
+
+// metricsHandler serves the metrics gathered from registry,
+// or, if the request has collect[] or match[] query parameters
+// or the client is restricted to some metrics,
+// only the selected metrics and series, along with the exporter and runtime metrics.
+func (s *server) metricsHandler(registry *prometheus.Registry, rc prometheus.Collector) http.Handler {
+	opts := promhttp.HandlerOpts{
+		ErrorHandling: promhttp.ContinueOnError,
+	}
+	promHandler := promhttp.HandlerFor(registry, opts)
+	return s.withAuth(func(w http.ResponseWriter, r *http.Request, allowed []string) {
+		filter, err := s.parseScrapeFilter(r, allowed)
+		if err == errForbiddenMetric {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Debugf("bad scrape request %q: %v", r.URL.String(), err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if filter == nil {
+			promHandler.ServeHTTP(w, r)
+			return
+		}
+		freg := prometheus.NewRegistry()
+		err = freg.Register(&filteredCollector{s: s, filter: filter})
+		if err == nil {
+			err = s.metrics.register(freg)
+		}
+		if err == nil {
+			err = freg.Register(rc)
+		}
+		if err != nil {
+			log.Errorf("failed to create filtered registry: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		promhttp.HandlerFor(freg, opts).ServeHTTP(w, r)
+	})
+}
+
+// parseScrapeFilter returns the scrape filter built from the request query parameters
+// and the metrics the client is allowed to scrape, nil if there is no restriction.
+func (s *server) parseScrapeFilter(r *http.Request, allowed []string) (*scrapeFilter, error) {
+	q := r.URL.Query()
+	groups := q[collectParam]
+	for _, l := range q[collectListParam] {
+		for _, g := range strings.Split(l, ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				groups = append(groups, g)
+			}
+		}
+	}
+	requested := len(groups) > 0
+	matches := q[matchParam]
+	if len(groups) == 0 && len(matches) == 0 && allowed == nil {
+		return nil, nil
+	}
+	f := &scrapeFilter{
+		match: make([]selector, 0, len(matches)),
+	}
+	for _, m := range matches {
+		sel, err := parseSelector(m)
+		if err != nil {
+			return nil, err
+		}
+		f.match = append(f.match, sel)
+	}
+	if len(groups) == 0 {
+		// restricted clients scrape all their allowed metrics
+		groups = allowed
+	}
+	if groups == nil {
+		return f, nil
+	}
+	s.config.m.Lock()
+	defer s.config.m.Unlock()
+	enabled := s.enabledMetrics()
+	f.groups = make(map[string]struct{}, len(groups))
+	for _, g := range groups {
+		if allowed != nil && !contains(allowed, g) {
+			return nil, errForbiddenMetric
+		}
+		if _, ok := enabled[g]; !ok {
+			if !requested {
+				// allowed metric that is not enabled
+				continue
+			}
+			return nil, fmt.Errorf("unknown or disabled metric %q", g)
+		}
+		f.groups[g] = struct{}{}
+	}
+	return f, nil
+}
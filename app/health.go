@@ -0,0 +1,154 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/connectivity"
+)
+
+const (
+	healthPassing  = "passing"
+	healthWarning  = "warning"
+	healthCritical = "critical"
+
+	defaultHealthWindow            = 5 * time.Minute
+	defaultHealthWarningThreshold  = 90
+	defaultHealthCriticalThreshold = 50
+	// bounds of the collection results kept to compute the success ratio
+	maxHealthWindow         = time.Hour
+	maxCollectionResultsLen = 10000
+)
+
+// healthConfig sets the collection success ratios, in percent,
+// under which the registration health check is warning or critical.
+type healthConfig struct {
+	Window            stringValue `json:"window,omitempty"`
+	WarningThreshold  uint32Value `json:"warning_threshold,omitempty"`
+	CriticalThreshold uint32Value `json:"critical_threshold,omitempty"`
+}
+
+// exporterHealth is the health reported to the service registration backend.
+type exporterHealth struct {
+	status string
+	output string
+}
+
+type collectionResult struct {
+	t  time.Time
+	ok bool
+}
+
+// collectionResults keeps the outcome of the recent metric collections.
+type collectionResults struct {
+	m       *sync.Mutex
+	results []collectionResult
+}
+
+func newCollectionResults() *collectionResults {
+	return &collectionResults{m: new(sync.Mutex)}
+}
+
+func (c *collectionResults) add(ok bool) {
+	now := time.Now()
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.results = append(c.results, collectionResult{t: now, ok: ok})
+	// drop the results older than the max window
+	i := 0
+	for i < len(c.results) && (now.Sub(c.results[i].t) > maxHealthWindow || len(c.results)-i > maxCollectionResultsLen) {
+		i++
+	}
+	if i > 0 {
+		c.results = append(c.results[:0], c.results[i:]...)
+	}
+}
+
+// ratio returns the number of successful collections and the total number of collections
+// in the last window.
+func (c *collectionResults) ratio(window time.Duration) (int, int) {
+	since := time.Now().Add(-window)
+	c.m.Lock()
+	defer c.m.Unlock()
+	var success, total int
+	for i := len(c.results) - 1; i >= 0 && c.results[i].t.After(since); i-- {
+		total++
+		if c.results[i].ok {
+			success++
+		}
+	}
+	return success, total
+}
+
+// health returns the exporter health derived from the HTTP listener state,
+// the gNMI connection state and the recent collections success ratio.
+func (s *server) health() *exporterHealth {
+	s.config.m.Lock()
+	operState := s.config.baseConfig.OperState
+	var hcfg *healthConfig
+	if s.config.baseConfig.Registration != nil {
+		hcfg = s.config.baseConfig.Registration.Health
+	}
+	s.config.m.Unlock()
+
+	window := defaultHealthWindow
+	warning := uint32(defaultHealthWarningThreshold)
+	critical := uint32(defaultHealthCriticalThreshold)
+	if hcfg != nil {
+		if d, err := time.ParseDuration(hcfg.Window.Value); err == nil && d > 0 {
+			window = d
+		}
+		if hcfg.WarningThreshold.Value > 0 {
+			warning = hcfg.WarningThreshold.Value
+		}
+		if hcfg.CriticalThreshold.Value > 0 {
+			critical = hcfg.CriticalThreshold.Value
+		}
+	}
+	if window > maxHealthWindow {
+		window = maxHealthWindow
+	}
+
+	h := &exporterHealth{status: healthPassing}
+	degrade := func(status string) {
+		if status == healthCritical || h.status == healthPassing {
+			h.status = status
+		}
+	}
+	outputs := make([]string, 0, 3)
+
+	if operState == operUp {
+		outputs = append(outputs, "http listener: up")
+	} else {
+		outputs = append(outputs, "http listener: down")
+		degrade(healthCritical)
+	}
+
+	gnmiState := s.gnmi.getState()
+	outputs = append(outputs, fmt.Sprintf("gnmi connection: %s", strings.ToLower(gnmiState.String())))
+	switch gnmiState {
+	case connectivity.Ready:
+	case connectivity.Idle, connectivity.Connecting:
+		degrade(healthWarning)
+	default:
+		degrade(healthCritical)
+	}
+
+	success, total := s.collections.ratio(window)
+	if total == 0 {
+		outputs = append(outputs, fmt.Sprintf("collections: none in the last %s", window))
+	} else {
+		pct := uint32(success * 100 / total)
+		outputs = append(outputs, fmt.Sprintf("collections: %d/%d (%d%%) successful in the last %s", success, total, pct, window))
+		switch {
+		case pct < critical:
+			degrade(healthCritical)
+		case pct < warning:
+			degrade(healthWarning)
+		}
+	}
+	h.output = strings.Join(outputs, ", ")
+	return h
+}
@@ -0,0 +1,251 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	identityRefreshInterval = time.Minute
+)
+
+// identityLabels selects the system information fields
+// added as labels to all the exported series.
+type identityLabels struct {
+	HostName            boolValue `json:"host_name,omitempty"`
+	SoftwareVersion     boolValue `json:"software_version,omitempty"`
+	ChassisType         boolValue `json:"chassis_type,omitempty"`
+	ChassisMacAddress   boolValue `json:"chassis_mac_address,omitempty"`
+	ChassisSerialNumber boolValue `json:"chassis_serial_number,omitempty"`
+	ChassisPartNumber   boolValue `json:"chassis_part_number,omitempty"`
+}
+
+func (il *identityLabels) enabled() bool {
+	return il != nil && (il.HostName.Value || il.SoftwareVersion.Value || il.ChassisType.Value ||
+		il.ChassisMacAddress.Value || il.ChassisSerialNumber.Value || il.ChassisPartNumber.Value)
+}
+
+// labels returns the selected identity labels from the system information.
+func (il *identityLabels) labels(sysInfo *systemInfo) map[string]string {
+	lbls := make(map[string]string)
+	if il == nil || sysInfo == nil {
+		return lbls
+	}
+	if il.HostName.Value {
+		lbls["host_name"] = sysInfo.Name
+	}
+	if il.SoftwareVersion.Value {
+		lbls["software_version"] = sysInfo.Version
+	}
+	if il.ChassisType.Value {
+		lbls["chassis_type"] = sysInfo.ChassisType
+	}
+	if il.ChassisMacAddress.Value {
+		lbls["chassis_mac_address"] = sysInfo.ChassisMacAddress
+	}
+	if il.ChassisSerialNumber.Value {
+		lbls["chassis_serial_number"] = sysInfo.ChassisSerialNumber
+	}
+	if il.ChassisPartNumber.Value {
+		lbls["chassis_part_number"] = sysInfo.ChassisPartNumber
+	}
+	return lbls
+}
+
+// identityCache holds the last known system information.
+type identityCache struct {
+	m       *sync.RWMutex
+	sysInfo *systemInfo
+}
+
+func newIdentityCache() *identityCache {
+	return &identityCache{m: new(sync.RWMutex)}
+}
+
+func (c *identityCache) get() *systemInfo {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.sysInfo
+}
+
+// set stores sysInfo and returns true if the host name changed.
+func (c *identityCache) set(sysInfo *systemInfo) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	changed := c.sysInfo == nil || c.sysInfo.Name != sysInfo.Name
+	c.sysInfo = sysInfo
+	return changed
+}
+
+// hostNamePath is subscribed to with ON_CHANGE to refresh the identity
+// as soon as the system name changes.
+var hostNamePath = &gnmi.Path{
+	Elem: []*gnmi.PathElem{
+		{Name: "system"},
+		{Name: "name"},
+		{Name: "host-name"},
+	},
+}
+
+// syncIdentity triggers an identity refresh, used when the identity labels config changes.
+func (s *server) syncIdentity() {
+	select {
+	case s.identitySync <- struct{}{}:
+	default:
+	}
+}
+
+// identityNeeded returns true if identity labels, the otlp export or the pushgateway push are enabled.
+func (s *server) identityNeeded() bool {
+	s.config.m.Lock()
+	defer s.config.m.Unlock()
+	return s.config.baseConfig.IdentityLabels.enabled() || s.otlpEnabled() || s.pushgatewayEnabled()
+}
+
+// watchIdentity refreshes the identity cache when the system name
+// or the identity labels config change, and periodically to catch the other fields,
+// while identity labels, the otlp export or the pushgateway push are enabled.
+func (s *server) watchIdentity(ctx context.Context) {
+	hostNameChanged := make(chan struct{}, 1)
+	go s.watchHostName(ctx, hostNameChanged)
+
+	ticker := time.NewTicker(identityRefreshInterval)
+	defer ticker.Stop()
+	for {
+		if s.identityNeeded() {
+			s.refreshIdentity(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.identitySync:
+		case <-hostNameChanged:
+		}
+	}
+}
+
+// watchHostName signals changed each time the system host name changes,
+// the subscription is restarted on failure until ctx is canceled.
+func (s *server) watchHostName(ctx context.Context, changed chan<- struct{}) {
+	for {
+		err := s.subscribeHostName(ctx, changed)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Errorf("system host-name subscription failed: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (s *server) subscribeHostName(ctx context.Context, changed chan<- struct{}) error {
+	gnmiClient, err := s.gnmi.gnmiClient(ctx)
+	if err != nil {
+		return err
+	}
+	subClient, err := gnmiClient.Subscribe(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create a subscribe client: %v", err)
+	}
+	err = subClient.Send(&gnmi.SubscribeRequest{
+		Request: &gnmi.SubscribeRequest_Subscribe{
+			Subscribe: &gnmi.SubscriptionList{
+				Mode:     gnmi.SubscriptionList_STREAM,
+				Encoding: gnmi.Encoding_ASCII,
+				Subscription: []*gnmi.Subscription{
+					{Path: hostNamePath, Mode: gnmi.SubscriptionMode_ON_CHANGE},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send a subscribe request: %v", err)
+	}
+	// the initial updates carry the current host name,
+	// it is already read by the first refresh
+	synced := false
+	for {
+		subResp, err := subClient.Recv()
+		if err == io.EOF {
+			return fmt.Errorf("subscription closed by the server")
+		}
+		if err != nil {
+			return err
+		}
+		switch subResp.GetResponse().(type) {
+		case *gnmi.SubscribeResponse_SyncResponse:
+			synced = true
+		case *gnmi.SubscribeResponse_Update:
+			if !synced {
+				continue
+			}
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (s *server) refreshIdentity(ctx context.Context) {
+	sysInfo, err := s.getSystemInfo(ctx)
+	if err != nil {
+		log.Errorf("failed to refresh system identity: %v", err)
+		return
+	}
+	if s.identity.set(sysInfo) {
+		log.Infof("system identity updated, host-name: %q", sysInfo.Name)
+	}
+}
+
+// constLabels returns the labels added to all the series of metric name:
+// the identity labels, the exporter static labels and the metric static labels,
+// in increasing order of precedence.
+// assumes config is already locked
+func (s *server) constLabels(name string) map[string]string {
+	lbls := s.config.baseConfig.IdentityLabels.labels(s.identity.get())
+	for k, v := range s.config.staticLabels {
+		lbls[k] = v
+	}
+	if m, ok := s.config.metrics[name]; ok {
+		for k, v := range m.staticLabels {
+			lbls[k] = v
+		}
+	}
+	if m, ok := s.config.customMetric[name]; ok {
+		for k, v := range m.staticLabels {
+			lbls[k] = v
+		}
+	}
+	return lbls
+}
+
+// addLabels appends the extra labels that are not already present in labels.
+func addLabels(labels, values []string, extra map[string]string) ([]string, []string) {
+	if len(extra) == 0 {
+		return labels, values
+	}
+	names := make([]string, 0, len(extra))
+	for k := range extra {
+		if !contains(labels, k) {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		labels = append(labels, k)
+		values = append(values, extra[k])
+	}
+	return labels, values
+}
@@ -0,0 +1,209 @@
+package app
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/openconfig/gnmic/pkg/formatters"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	metricModeInfo = "info"
+	infoSuffix     = "info"
+)
+
+// isInfoMetric returns true if metric name is configured in info mode.
+// assumes config is already locked
+func (s *server) isInfoMetric(name string) bool {
+	if mo, ok := s.config.metricOptions[name]; ok && mo != nil && strings.ToLower(mo.Mode) == metricModeInfo {
+		return true
+	}
+	if cm, ok := s.config.customMetric[name]; ok {
+		// YANG enums are received as MODE_<value>
+		return strings.TrimPrefix(cm.Metric.Mode, "MODE_") == metricModeInfo
+	}
+	return false
+}
+
+// infoLeaves returns the leaves of metric name that are exposed as info labels,
+// an empty list means all the leaves.
+// assumes config is already locked
+func (s *server) infoLeaves(name string) map[string]string {
+	leaves := make(map[string]string)
+	if mo, ok := s.config.metricOptions[name]; ok && mo != nil {
+		for _, l := range mo.InfoLeaves {
+			leaves[l] = l
+		}
+	}
+	if cm, ok := s.config.customMetric[name]; ok {
+		for _, l := range cm.Metric.InfoLeaves {
+			leaves[l.Value] = l.Value
+		}
+	}
+	return leaves
+}
+
+// infoValue returns the label value of leaf value v,
+// only string, numeric and bool values are exposed.
+func infoValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case bool, float32, float64,
+		int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
+
+// infoLabelNames returns the label name of each of the value names of metric name.
+// The leaf name is used alone unless it clashes with another leaf name,
+// in which case the clashing leaves keep their path relative to the
+// subscription paths common prefix, or their full path.
+// assumes config is already locked
+func (s *server) infoLabelNames(name string, valueNames []string) map[string]string {
+	commonPrefix := s.commonPathPrefix(name)
+	leafCount := make(map[string]int, len(valueNames))
+	for _, vname := range valueNames {
+		leafCount[s.joinMetricName(path.Base(vname))]++
+	}
+	labelNames := make(map[string]string, len(valueNames))
+	added := make(map[string]struct{}, len(valueNames))
+	for _, vname := range valueNames {
+		labelName := s.joinMetricName(path.Base(vname))
+		if leafCount[labelName] > 1 {
+			p := stripModulePrefixes(vname)
+			if commonPrefix != "" && strings.HasPrefix(p, commonPrefix+"/") {
+				p = p[len(commonPrefix)+1:]
+			}
+			labelName = s.joinMetricName(p)
+		}
+		// still clashing, keep the full path
+		if _, ok := added[labelName]; ok {
+			labelName = s.joinMetricName(vname)
+		}
+		if _, ok := added[labelName]; ok {
+			continue
+		}
+		labelNames[vname] = labelName
+		added[labelName] = struct{}{}
+	}
+	return labelNames
+}
+
+// emitInfoMetrics exposes the string, numeric and bool leaves of the events as labels of a
+// <metric>_info series with value 1, one series per set of list keys.
+// The series not selected by sf are skipped.
+// It returns the number of series emitted.
+// assumes config is already locked
+func (s *server) emitInfoMetrics(ch chan<- prometheus.Metric, name string, m metric, events []*formatters.EventMsg, sf *seriesFilter) int {
+	selected := s.infoLeaves(name)
+
+	type infoSeries struct {
+		ev   *formatters.EventMsg
+		info map[string]string
+	}
+	// merge the events with the same keys
+	series := make(map[string]*infoSeries)
+	keys := make([]string, 0)
+	infoLeaves := make(map[string]struct{})
+	for _, ev := range events {
+		k := tagsKey(ev.Tags)
+		is, ok := series[k]
+		if !ok {
+			is = &infoSeries{ev: ev, info: make(map[string]string)}
+			series[k] = is
+			keys = append(keys, k)
+		}
+		for vname, v := range ev.Values {
+			sv, ok := infoValue(v)
+			if !ok {
+				continue
+			}
+			if len(selected) > 0 {
+				if _, ok := lookupLeaf(selected, vname); !ok {
+					continue
+				}
+			}
+			is.info[vname] = sv
+			infoLeaves[vname] = struct{}{}
+		}
+	}
+	if len(infoLeaves) == 0 {
+		return 0
+	}
+	// all the series get the same info label names
+	valueNames := make([]string, 0, len(infoLeaves))
+	for vname := range infoLeaves {
+		valueNames = append(valueNames, vname)
+	}
+	sort.Strings(valueNames)
+	infoLabelNames := s.infoLabelNames(name, valueNames)
+
+	rules := s.relabelRules(name)
+	metricName := s.joinMetricName(s.config.baseConfig.MetricPrefix.Value, name, infoSuffix)
+	constLabels := s.constLabels(name)
+	var count int
+	sort.Strings(keys)
+	for _, k := range keys {
+		is := series[k]
+		labels, values := s.getLabels(is.ev)
+		labels, values = addLabels(labels, values, constLabels)
+		for _, vname := range valueNames {
+			labelName, ok := infoLabelNames[vname]
+			if !ok {
+				continue
+			}
+			// do not override the list keys labels
+			if contains(labels, labelName) {
+				labelName = infoSuffix + "_" + labelName
+			}
+			if contains(labels, labelName) {
+				log.Debugf("metric %q: dropping info leaf %q, label %q already exists", name, vname, labelName)
+				continue
+			}
+			labels = append(labels, labelName)
+			values = append(values, is.info[vname])
+		}
+		if len(rules) > 0 {
+			var keep bool
+			labels, values, keep = relabel(rules, labels, values)
+			if !keep {
+				continue
+			}
+		}
+		if sf != nil {
+			lbls := labelsMap(labels, values)
+			lbls[metricNameLabel] = metricName
+			if !sf.keep(lbls) {
+				continue
+			}
+		}
+		pm, err := prometheus.NewConstMetric(
+			prometheus.NewDesc(metricName, m.HelpText.Value, labels, nil),
+			prometheus.GaugeValue,
+			1,
+			values...)
+		if err != nil {
+			s.metrics.invalidSample(name, err)
+			continue
+		}
+		ch <- pm
+		count++
+	}
+	return count
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/openconfig/gnmic/pkg/formatters"
+)
+
+const (
+	labelNamingLegacy    = "LABEL_NAMING_legacy"
+	labelNamingKey       = "LABEL_NAMING_key"
+	labelNamingQualified = "LABEL_NAMING_qualified"
+)
+
+// splitTag splits an event tag name into its list context and key name.
+// Tags are named <list>_<key>, or <path>_<key> when the same key
+// appears with different values in the notification prefix and path.
+func splitTag(tag string) (string, string) {
+	idx := strings.LastIndex(tag, "_")
+	if idx < 0 {
+		return "", tag
+	}
+	return strings.Trim(tag[:idx], "/"), tag[idx+1:]
+}
+
+// getKeyLabels builds deterministic label names from the event tags.
+// With qualified set, label names are always <list>_<key>,
+// otherwise the key name is used alone unless it clashes with another key name,
+// in which case all the clashing keys keep their list context.
+func (s *server) getKeyLabels(ev *formatters.EventMsg, qualified bool) ([]string, []string) {
+	tags := make([]string, 0, len(ev.Tags))
+	keyCount := make(map[string]int, len(ev.Tags))
+	for k := range ev.Tags {
+		tags = append(tags, k)
+		_, key := splitTag(k)
+		keyCount[key]++
+	}
+	sort.Strings(tags)
+
+	labels := make([]string, 0, len(tags))
+	values := make([]string, 0, len(tags))
+	addedLabels := make(map[string]struct{}, len(tags))
+	for _, k := range tags {
+		list, key := splitTag(k)
+		labelName := key
+		if (qualified || keyCount[key] > 1) && list != "" {
+			labelName = list + "_" + key
+		}
+		labelName = strings.Trim(s.metricRegex.ReplaceAllString(labelName, "_"), "_")
+		// still clashing after sanitization, keep the full tag name
+		if _, ok := addedLabels[labelName]; ok {
+			labelName = strings.Trim(s.metricRegex.ReplaceAllString(k, "_"), "_")
+		}
+		if _, ok := addedLabels[labelName]; ok {
+			continue
+		}
+		labels = append(labels, labelName)
+		values = append(values, ev.Tags[k])
+		addedLabels[labelName] = struct{}{}
+	}
+	return labels, values
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/openconfig/gnmic/pkg/formatters"
+)
+
+func TestGetKeyLabels(t *testing.T) {
+	tests := []struct {
+		name      string
+		tags      map[string]string
+		qualified bool
+		// expected label names and values, in order
+		labels []string
+		values []string
+	}{
+		{
+			name:   "distinct keys",
+			tags:   map[string]string{"interface_name": "ethernet-1/1", "subinterface_index": "0"},
+			labels: []string{"name", "index"},
+			values: []string{"ethernet-1/1", "0"},
+		},
+		{
+			name:      "qualified",
+			tags:      map[string]string{"interface_name": "ethernet-1/1", "subinterface_index": "0"},
+			qualified: true,
+			labels:    []string{"interface_name", "subinterface_index"},
+			values:    []string{"ethernet-1/1", "0"},
+		},
+		{
+			name: "colliding keys keep their list",
+			tags: map[string]string{
+				"network-instance_name": "default",
+				"interface_name":        "ethernet-1/1",
+				"subinterface_index":    "0",
+			},
+			labels: []string{"interface_name", "network_instance_name", "index"},
+			values: []string{"ethernet-1/1", "default", "0"},
+		},
+		{
+			name: "colliding key in the prefix and the path",
+			tags: map[string]string{
+				"network-instance_name":                                "default",
+				"network-instance/protocols/bgp/neighbor_peer-address": "10.0.0.1",
+				"protocols/bgp/neighbor_peer-address":                  "10.0.0.2",
+			},
+			labels: []string{"network_instance_protocols_bgp_neighbor_peer_address", "name", "protocols_bgp_neighbor_peer_address"},
+			values: []string{"10.0.0.1", "default", "10.0.0.2"},
+		},
+		{
+			name:   "keys colliding after sanitization use the full tag",
+			tags:   map[string]string{"x_if-index": "1", "y_if.index": "2"},
+			labels: []string{"if_index", "y_if_index"},
+			values: []string{"1", "2"},
+		},
+		{
+			name:   "tags colliding after sanitization are dropped",
+			tags:   map[string]string{"a-b_name": "1", "a_b_name": "2"},
+			labels: []string{"a_b_name"},
+			values: []string{"1"},
+		},
+		{
+			name:   "key without list",
+			tags:   map[string]string{"source": "srl1", "interface_name": "mgmt0"},
+			labels: []string{"name", "source"},
+			values: []string{"mgmt0", "srl1"},
+		},
+	}
+	s := NewServer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the label order must not depend on the map iteration order
+			for i := 0; i < 10; i++ {
+				labels, values := s.getKeyLabels(&formatters.EventMsg{Tags: tt.tags}, tt.qualified)
+				if len(labels) != len(tt.labels) || len(values) != len(tt.values) {
+					t.Fatalf("got labels %v=%v, expected %v=%v", labels, values, tt.labels, tt.values)
+				}
+				for j := range tt.labels {
+					if labels[j] != tt.labels[j] || values[j] != tt.values[j] {
+						t.Fatalf("got labels %v=%v, expected %v=%v", labels, values, tt.labels, tt.values)
+					}
+				}
+			}
+		})
+	}
+}
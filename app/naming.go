@@ -0,0 +1,116 @@
+package app
+
+import (
+	"path"
+	"strings"
+
+	gpath "github.com/openconfig/gnmic/pkg/path"
+)
+
+const (
+	metricNamingBasename = "METRIC_NAMING_basename"
+	metricNamingPath     = "METRIC_NAMING_path"
+	metricNamingGNMIc    = "METRIC_NAMING_gnmic"
+)
+
+// metricNamer returns a function that builds the prometheus metric name
+// of a value of metric name, based on the configured naming strategy,
+// namespace prefix and per leaf overrides.
+// assumes config is already locked
+func (s *server) metricNamer(name string) func(valueName string) string {
+	var overrides map[string]string
+	if mo, ok := s.config.metricOptions[name]; ok && mo != nil {
+		overrides = mo.LeafNames
+	}
+	namespace := s.config.baseConfig.MetricPrefix.Value
+	strategy := s.config.baseConfig.MetricNaming
+
+	var commonPrefix string
+	if strategy == metricNamingPath {
+		commonPrefix = s.commonPathPrefix(name)
+	}
+	return func(valueName string) string {
+		elems := make([]string, 0, 3)
+		if namespace != "" {
+			elems = append(elems, namespace)
+		}
+		if override, ok := lookupLeaf(overrides, valueName); ok {
+			elems = append(elems, override)
+			return s.joinMetricName(elems...)
+		}
+		switch strategy {
+		case metricNamingPath:
+			valueName = stripModulePrefixes(valueName)
+			relPath := path.Base(valueName)
+			if strings.HasPrefix(valueName, commonPrefix+"/") {
+				relPath = valueName[len(commonPrefix)+1:]
+			}
+			elems = append(elems, name, relPath)
+		case metricNamingGNMIc:
+			elems = append(elems, name, valueName)
+		default:
+			elems = append(elems, name, path.Base(valueName))
+		}
+		return s.joinMetricName(elems...)
+	}
+}
+
+// joinMetricName sanitizes each element and joins them with "_".
+func (s *server) joinMetricName(elems ...string) string {
+	parts := make([]string, 0, len(elems))
+	for _, e := range elems {
+		e = strings.Trim(s.metricRegex.ReplaceAllString(e, "_"), "_")
+		if e != "" {
+			parts = append(parts, e)
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+// commonPathPrefix returns the longest common path (without keys) of
+// the subscription paths of metric name.
+// assumes config is already locked
+func (s *server) commonPathPrefix(name string) string {
+	paths, err := s.metricPaths(name)
+	if err != nil || len(paths) == 0 {
+		return ""
+	}
+	var common []string
+	for i, p := range paths {
+		gp, err := gpath.ParsePath(p)
+		if err != nil {
+			return ""
+		}
+		elems := make([]string, 0, len(gp.GetElem()))
+		for _, e := range gp.GetElem() {
+			elems = append(elems, stripModulePrefixes(e.GetName()))
+		}
+		if i == 0 {
+			common = elems
+			continue
+		}
+		n := 0
+		for n < len(common) && n < len(elems) && common[n] == elems[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	if len(common) == 0 {
+		return ""
+	}
+	return "/" + strings.Join(common, "/")
+}
+
+// stripModulePrefixes removes the YANG module prefixes from each element of path p.
+func stripModulePrefixes(p string) string {
+	if !strings.Contains(p, ":") {
+		return p
+	}
+	elems := strings.Split(p, "/")
+	for i, e := range elems {
+		if idx := strings.Index(e, ":"); idx >= 0 {
+			elems[i] = e[idx+1:]
+		}
+	}
+	return strings.Join(elems, "/")
+}
@@ -0,0 +1,60 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netns"
+)
+
+// networkInstanceNetNS returns the namespace of the configured network instance,
+// it waits for the network instance to be known.
+func (s *server) networkInstanceNetNS(ctx context.Context) (netns.NsHandle, error) {
+	for {
+		s.config.m.Lock()
+		nwInstName := s.config.baseConfig.NetworkInstance.Value
+		netInst, ok := s.config.nwInst[nwInstName]
+		s.config.m.Unlock()
+		if ok {
+			return netns.GetFromName(fmt.Sprintf("%s-%s", netInst.BaseName, nwInstName))
+		}
+		log.Errorf("unknown network instance name: %s", nwInstName)
+		select {
+		case <-ctx.Done():
+			return netns.None(), ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// netnsDialContext returns a DialContext function creating the connections
+// in namespace n, the calling goroutine namespace is restored after each dial.
+// If n is not open, the connections are created in the current namespace.
+func netnsDialContext(n netns.NsHandle) func(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 5 * time.Second,
+	}
+	if !n.IsOpen() {
+		return dialer.DialContext
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		orig, err := netns.Get()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current NetNS: %v", err)
+		}
+		defer orig.Close()
+		err = netns.Set(n)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set NetNS %q: %v", n.UniqueId(), err)
+		}
+		defer netns.Set(orig)
+		return dialer.DialContext(ctx, network, address)
+	}
+}
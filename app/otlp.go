@@ -0,0 +1,468 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nokia/srlinux-ndk-go/ndk"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	otlpProtocolGRPC = "PROTOCOL_grpc"
+	otlpProtocolHTTP = "PROTOCOL_http"
+
+	defaultOTLPInterval = 30 * time.Second
+	defaultOTLPTimeout  = 10 * time.Second
+	otlpHTTPMetricsPath = "/v1/metrics"
+)
+
+// processStartTime is the start time of the cumulative sums,
+// it does not change when the OTLP export is restarted.
+var processStartTime = time.Now()
+
+type otlp struct {
+	AdminState    string      `json:"admin_state,omitempty"`
+	Protocol      string      `json:"protocol,omitempty"`
+	Endpoint      stringValue `json:"endpoint,omitempty"`
+	Interval      stringValue `json:"interval,omitempty"`
+	Timeout       stringValue `json:"timeout,omitempty"`
+	Insecure      boolValue   `json:"insecure,omitempty"`
+	SkipVerify    boolValue   `json:"skip_verify,omitempty"`
+	CACertificate stringValue `json:"ca_certificate,omitempty"`
+}
+
+type otlpHeaderConfig struct {
+	Header struct {
+		Value stringValue `json:"value,omitempty"`
+	} `json:"header,omitempty"`
+}
+
+func (s *server) otlpEnabled() bool {
+	return s.config.baseConfig.OTLP != nil && s.config.baseConfig.OTLP.AdminState == adminEnable
+}
+
+// otlpExporter sends the gathered metrics to an OpenTelemetry collector.
+type otlpExporter struct {
+	protocol  string
+	endpoint  string
+	timeout   time.Duration
+	headers   map[string]string
+	tlsConfig *tls.Config
+
+	// gRPC
+	conn   *grpc.ClientConn
+	client colmetricspb.MetricsServiceClient
+	// HTTP
+	httpClient *http.Client
+}
+
+// startOTLP starts the OTLP export of the collected metrics.
+func (s *server) startOTLP(ctx context.Context) {
+	s.config.m.Lock()
+	defer s.config.m.Unlock()
+	s.startOTLPLocked(ctx)
+}
+
+// assumes config is already locked
+func (s *server) startOTLPLocked(ctx context.Context) {
+	s.stopOTLP()
+	if !s.otlpEnabled() || s.registry == nil {
+		return
+	}
+	cfg := s.config.baseConfig.OTLP
+	if cfg.Endpoint.Value == "" {
+		log.Error("otlp is enabled without endpoint")
+		return
+	}
+	interval := defaultOTLPInterval
+	if cfg.Interval.Value != "" {
+		d, err := time.ParseDuration(cfg.Interval.Value)
+		if err != nil || d <= 0 {
+			log.Errorf("invalid otlp interval %q", cfg.Interval.Value)
+		} else {
+			interval = d
+		}
+	}
+	exp := &otlpExporter{
+		protocol: cfg.Protocol,
+		endpoint: cfg.Endpoint.Value,
+		timeout:  defaultOTLPTimeout,
+		headers:  make(map[string]string, len(s.config.otlpHeaders)),
+	}
+	if cfg.Timeout.Value != "" {
+		d, err := time.ParseDuration(cfg.Timeout.Value)
+		if err != nil || d <= 0 {
+			log.Errorf("invalid otlp timeout %q", cfg.Timeout.Value)
+		} else {
+			exp.timeout = d
+		}
+	}
+	for k, h := range s.config.otlpHeaders {
+		exp.headers[k] = h.Header.Value.Value
+	}
+	if !cfg.Insecure.Value {
+		exp.tlsConfig = &tls.Config{InsecureSkipVerify: cfg.SkipVerify.Value}
+		if cfg.CACertificate.Value != "" {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM([]byte(cfg.CACertificate.Value)) {
+				log.Error("otlp: failed to parse ca-certificate")
+				return
+			}
+			exp.tlsConfig.RootCAs = pool
+		}
+	}
+	registry := s.registry
+	ctx, s.otlpCancelFn = context.WithCancel(ctx)
+	go s.runOTLP(ctx, registry, exp, interval)
+}
+
+func (s *server) stopOTLP() {
+	if s.otlpCancelFn != nil {
+		s.otlpCancelFn()
+		s.otlpCancelFn = nil
+	}
+}
+
+func (s *server) runOTLP(ctx context.Context, gatherer prometheus.Gatherer, exp *otlpExporter, interval time.Duration) {
+	n, err := s.networkInstanceNetNS(ctx)
+	if err != nil {
+		log.Errorf("otlp: failed to get the network instance namespace: %v", err)
+		return
+	}
+	defer n.Close()
+	err = exp.init(netnsDialContext(n))
+	if err != nil {
+		log.Errorf("otlp: %v", err)
+		return
+	}
+	defer exp.close()
+	if s.identity.get() == nil {
+		// resource attributes
+		s.refreshIdentity(ctx)
+	}
+	log.Infof("starting otlp export to %q every %s", exp.endpoint, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("otlp export stopped")
+			return
+		case <-ticker.C:
+		}
+		mfs, err := gatherer.Gather()
+		if err != nil {
+			// partial results are still exported
+			log.Errorf("otlp: gather error: %v", err)
+		}
+		req := &colmetricspb.ExportMetricsServiceRequest{
+			ResourceMetrics: []*metricspb.ResourceMetrics{{
+				Resource: &resourcepb.Resource{Attributes: s.otlpResourceAttributes()},
+				ScopeMetrics: []*metricspb.ScopeMetrics{{
+					Scope:   &commonpb.InstrumentationScope{Name: serviceName},
+					Metrics: metricFamiliesToOTLP(mfs, processStartTime, time.Now()),
+				}},
+			}},
+		}
+		err = exp.export(ctx, req)
+		if err != nil {
+			log.Errorf("otlp: export to %q failed: %v", exp.endpoint, err)
+			s.metrics.otlpExports.WithLabelValues("failed").Inc()
+			continue
+		}
+		s.metrics.otlpExports.WithLabelValues("sent").Inc()
+	}
+}
+
+func (exp *otlpExporter) init(dial func(ctx context.Context, network, address string) (net.Conn, error)) error {
+	switch exp.protocol {
+	case otlpProtocolHTTP:
+		exp.httpClient = &http.Client{
+			Timeout: exp.timeout,
+			Transport: &http.Transport{
+				DialContext:     dial,
+				TLSClientConfig: exp.tlsConfig,
+			},
+		}
+		return nil
+	default:
+		creds := insecure.NewCredentials()
+		if exp.tlsConfig != nil {
+			creds = credentials.NewTLS(exp.tlsConfig)
+		}
+		var err error
+		exp.conn, err = grpc.Dial(exp.endpoint,
+			grpc.WithTransportCredentials(creds),
+			grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+				return dial(ctx, "tcp", addr)
+			}),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create gRPC client: %v", err)
+		}
+		exp.client = colmetricspb.NewMetricsServiceClient(exp.conn)
+		return nil
+	}
+}
+
+func (exp *otlpExporter) close() {
+	if exp.conn != nil {
+		exp.conn.Close()
+	}
+}
+
+func (exp *otlpExporter) export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, exp.timeout)
+	defer cancel()
+	if exp.protocol != otlpProtocolHTTP {
+		for k, v := range exp.headers {
+			ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(k), v)
+		}
+		rsp, err := exp.client.Export(ctx, req)
+		if err != nil {
+			return err
+		}
+		if ps := rsp.GetPartialSuccess(); ps.GetRejectedDataPoints() > 0 {
+			log.Warnf("otlp: %d data points rejected: %s", ps.GetRejectedDataPoints(), ps.GetErrorMessage())
+		}
+		return nil
+	}
+	b, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, otlpHTTPURL(exp.endpoint, exp.tlsConfig != nil), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Content-Type", "application/x-protobuf")
+	hreq.Header.Set("User-Agent", serviceName)
+	for k, v := range exp.headers {
+		hreq.Header.Set(k, v)
+	}
+	rsp, err := exp.httpClient.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(rsp.Body, 512))
+		return fmt.Errorf("server returned HTTP status %s: %s", rsp.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(io.Discard, rsp.Body)
+	return nil
+}
+
+// otlpHTTPURL returns the OTLP/HTTP metrics URL of endpoint,
+// endpoint is either a URL or a host:port.
+func otlpHTTPURL(endpoint string, secure bool) string {
+	if !strings.Contains(endpoint, "://") {
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		endpoint = scheme + "://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err == nil && (u.Path == "" || u.Path == "/") {
+		u.Path = otlpHTTPMetricsPath
+		return u.String()
+	}
+	return endpoint
+}
+
+// otlpResourceAttributes returns the exporter resource attributes,
+// built from the device identity.
+func (s *server) otlpResourceAttributes() []*commonpb.KeyValue {
+	attrs := []*commonpb.KeyValue{stringAttribute("service.name", serviceName)}
+	sysInfo := s.identity.get()
+	if sysInfo == nil {
+		return attrs
+	}
+	for _, a := range []struct{ k, v string }{
+		{"host.name", sysInfo.Name},
+		{"os.version", sysInfo.Version},
+		{"srlinux.chassis.type", sysInfo.ChassisType},
+		{"srlinux.chassis.mac_address", sysInfo.ChassisMacAddress},
+		{"srlinux.chassis.serial_number", sysInfo.ChassisSerialNumber},
+		{"srlinux.chassis.part_number", sysInfo.ChassisPartNumber},
+	} {
+		if a.v != "" {
+			attrs = append(attrs, stringAttribute(a.k, a.v))
+		}
+	}
+	return attrs
+}
+
+func stringAttribute(k, v string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   k,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}},
+	}
+}
+
+// metricFamiliesToOTLP converts the gathered metric families to OTLP metrics,
+// counters are mapped to cumulative monotonic sums, gauges and untyped metrics to gauges.
+func metricFamiliesToOTLP(mfs []*dto.MetricFamily, start, now time.Time) []*metricspb.Metric {
+	startNano := uint64(start.UnixNano())
+	metrics := make([]*metricspb.Metric, 0, len(mfs))
+	for _, mf := range mfs {
+		m := &metricspb.Metric{
+			Name:        mf.GetName(),
+			Description: mf.GetHelp(),
+		}
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			sum := &metricspb.Sum{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+			}
+			for _, pm := range mf.GetMetric() {
+				sum.DataPoints = append(sum.DataPoints, numberDataPoint(pm, pm.GetCounter().GetValue(), startNano, now))
+			}
+			m.Data = &metricspb.Metric_Sum{Sum: sum}
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			gauge := new(metricspb.Gauge)
+			for _, pm := range mf.GetMetric() {
+				v := pm.GetGauge().GetValue()
+				if mf.GetType() == dto.MetricType_UNTYPED {
+					v = pm.GetUntyped().GetValue()
+				}
+				gauge.DataPoints = append(gauge.DataPoints, numberDataPoint(pm, v, 0, now))
+			}
+			m.Data = &metricspb.Metric_Gauge{Gauge: gauge}
+		case dto.MetricType_HISTOGRAM:
+			hist := &metricspb.Histogram{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			}
+			for _, pm := range mf.GetMetric() {
+				h := pm.GetHistogram()
+				sum := h.GetSampleSum()
+				dp := &metricspb.HistogramDataPoint{
+					Attributes:        labelPairsToAttributes(pm.GetLabel()),
+					StartTimeUnixNano: startNano,
+					TimeUnixNano:      dataPointTime(pm, startNano, now),
+					Count:             h.GetSampleCount(),
+					Sum:               &sum,
+				}
+				// prometheus buckets are cumulative, OTLP ones are not
+				var prev uint64
+				for _, b := range h.GetBucket() {
+					dp.ExplicitBounds = append(dp.ExplicitBounds, b.GetUpperBound())
+					dp.BucketCounts = append(dp.BucketCounts, b.GetCumulativeCount()-prev)
+					prev = b.GetCumulativeCount()
+				}
+				dp.BucketCounts = append(dp.BucketCounts, h.GetSampleCount()-prev)
+				hist.DataPoints = append(hist.DataPoints, dp)
+			}
+			m.Data = &metricspb.Metric_Histogram{Histogram: hist}
+		case dto.MetricType_SUMMARY:
+			summary := new(metricspb.Summary)
+			for _, pm := range mf.GetMetric() {
+				sm := pm.GetSummary()
+				dp := &metricspb.SummaryDataPoint{
+					Attributes:        labelPairsToAttributes(pm.GetLabel()),
+					StartTimeUnixNano: startNano,
+					TimeUnixNano:      dataPointTime(pm, startNano, now),
+					Count:             sm.GetSampleCount(),
+					Sum:               sm.GetSampleSum(),
+				}
+				for _, q := range sm.GetQuantile() {
+					dp.QuantileValues = append(dp.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+						Quantile: q.GetQuantile(),
+						Value:    q.GetValue(),
+					})
+				}
+				summary.DataPoints = append(summary.DataPoints, dp)
+			}
+			m.Data = &metricspb.Metric_Summary{Summary: summary}
+		default:
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func numberDataPoint(pm *dto.Metric, v float64, startNano uint64, now time.Time) *metricspb.NumberDataPoint {
+	return &metricspb.NumberDataPoint{
+		Attributes:        labelPairsToAttributes(pm.GetLabel()),
+		StartTimeUnixNano: startNano,
+		TimeUnixNano:      dataPointTime(pm, startNano, now),
+		Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: v},
+	}
+}
+
+// dataPointTime returns the device timestamp of pm if set, or now.
+// The returned time is never earlier than the start of the cumulative data point startNano, if set.
+func dataPointTime(pm *dto.Metric, startNano uint64, now time.Time) uint64 {
+	t := uint64(now.UnixNano())
+	if pm.TimestampMs != nil {
+		t = uint64(pm.GetTimestampMs()) * uint64(time.Millisecond)
+	}
+	if t < startNano {
+		return startNano
+	}
+	return t
+}
+
+func labelPairsToAttributes(lps []*dto.LabelPair) []*commonpb.KeyValue {
+	attrs := make([]*commonpb.KeyValue, 0, len(lps))
+	for _, lp := range lps {
+		attrs = append(attrs, stringAttribute(lp.GetName(), lp.GetValue()))
+	}
+	return attrs
+}
+
+func (s *server) handleCfgOTLPHeaderCreateChange(ctx context.Context, cfg *ndk.ConfigNotification) {
+	name := cfg.Key.Keys[0]
+	newHeaderConfig := new(otlpHeaderConfig)
+	err := json.Unmarshal([]byte(cfg.GetData().GetJson()), newHeaderConfig)
+	if err != nil {
+		log.Errorf("failed to marshal config data from path %s: %v", cfg.Key.JsPath, err)
+		return
+	}
+	s.config.otlpHeaders[name] = newHeaderConfig
+	s.updateOTLPHeaderTelemetry(ctx, name, newHeaderConfig)
+	s.restartOTLP(ctx)
+}
+
+func (s *server) handleCfgOTLPHeaderDelete(ctx context.Context, cfg *ndk.ConfigNotification) {
+	name := cfg.Key.Keys[0]
+	delete(s.config.otlpHeaders, name)
+	s.deleteOTLPHeaderTelemetry(ctx, name)
+	s.restartOTLP(ctx)
+}
+
+// restartOTLP restarts the OTLP export if it is running,
+// to apply a header change.
+// assumes config is already locked
+func (s *server) restartOTLP(ctx context.Context) {
+	if s.config.baseConfig.OperState != operUp {
+		return
+	}
+	s.startOTLPLocked(ctx)
+}
@@ -0,0 +1,260 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netns"
+)
+
+const (
+	defaultPushgatewayInterval = 30 * time.Second
+	defaultPushgatewayTimeout  = 10 * time.Second
+
+	pushgatewayJobLabel          = "job"
+	pushgatewayInstanceLabel     = "instance"
+	pushgatewaySerialNumberLabel = "serial_number"
+	// prefix of the series labels colliding with the job and grouping key labels
+	pushgatewayExportedPrefix = "exported_"
+)
+
+type pushgateway struct {
+	AdminState string      `json:"admin_state,omitempty"`
+	URL        stringValue `json:"url,omitempty"`
+	Job        stringValue `json:"job,omitempty"`
+	Interval   stringValue `json:"interval,omitempty"`
+	Timeout    stringValue `json:"timeout,omitempty"`
+	Username   stringValue `json:"username,omitempty"`
+	Password   secretValue `json:"password,omitempty"`
+	SkipVerify boolValue   `json:"skip_verify,omitempty"`
+}
+
+func (s *server) pushgatewayEnabled() bool {
+	return s.config.baseConfig.Pushgateway != nil && s.config.baseConfig.Pushgateway.AdminState == adminEnable
+}
+
+// pushgatewayPusher pushes the registry metric families to a Pushgateway.
+type pushgatewayPusher struct {
+	cfg      pushgateway
+	interval time.Duration
+	client   *http.Client
+	gatherer prometheus.Gatherer
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	// closed when the previous pusher is stopped, nil if there is none
+	prev chan struct{}
+	// network instance namespace, kept open for the grouping deletion
+	ns netns.NsHandle
+	// grouping key of the last push, deleted when the pusher stops
+	grouping map[string]string
+}
+
+func (p *pushgatewayPusher) pusher(grouping map[string]string) *push.Pusher {
+	job := p.cfg.Job.Value
+	if job == "" {
+		job = serviceName
+	}
+	pusher := push.New(p.cfg.URL.Value, job).
+		Gatherer(p.gatherer).
+		Client(p.client)
+	for k, v := range grouping {
+		pusher = pusher.Grouping(k, v)
+	}
+	if p.cfg.Username.Value != "" {
+		pusher = pusher.BasicAuth(p.cfg.Username.Value, p.cfg.Password.Value)
+	}
+	return pusher
+}
+
+// startPushgateway starts pushing the registry metric families to the configured Pushgateway.
+func (s *server) startPushgateway(ctx context.Context) {
+	s.config.m.Lock()
+	defer s.config.m.Unlock()
+	s.startPushgatewayLocked(ctx)
+}
+
+// assumes config is already locked
+func (s *server) startPushgatewayLocked(ctx context.Context) {
+	// the grouping is deleted if the push is disabled or pushed elsewhere,
+	// not when only the interval, timeout or credentials change
+	del := !s.pushgatewayEnabled() || s.registry == nil
+	if old := s.pushgw; !del && old != nil {
+		del = old.cfg.URL != s.config.baseConfig.Pushgateway.URL || old.cfg.Job != s.config.baseConfig.Pushgateway.Job
+	}
+	s.stopPushgateway(del)
+	if !s.pushgatewayEnabled() || s.registry == nil {
+		return
+	}
+	cfg := *s.config.baseConfig.Pushgateway
+	if cfg.URL.Value == "" {
+		log.Error("pushgateway is enabled without url")
+		return
+	}
+	p := &pushgatewayPusher{
+		cfg:      cfg,
+		interval: defaultPushgatewayInterval,
+		gatherer: pushgatewayGatherer{s.registry},
+		done:     make(chan struct{}),
+		prev:     s.pushgwStopped,
+		ns:       netns.None(),
+	}
+	if cfg.Interval.Value != "" {
+		d, err := time.ParseDuration(cfg.Interval.Value)
+		if err != nil || d <= 0 {
+			log.Errorf("invalid pushgateway interval %q", cfg.Interval.Value)
+		} else {
+			p.interval = d
+		}
+	}
+	timeout := defaultPushgatewayTimeout
+	if cfg.Timeout.Value != "" {
+		d, err := time.ParseDuration(cfg.Timeout.Value)
+		if err != nil || d <= 0 {
+			log.Errorf("invalid pushgateway timeout %q", cfg.Timeout.Value)
+		} else {
+			timeout = d
+		}
+	}
+	p.client = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: cfg.SkipVerify.Value},
+		},
+	}
+	ctx, p.cancel = context.WithCancel(ctx)
+	s.pushgw = p
+	go s.runPushgateway(ctx, p)
+}
+
+// stopPushgateway stops the running pusher,
+// and deletes its grouping from the Pushgateway if del is true.
+// The next pusher waits for the deletion before its first push.
+// assumes config is already locked
+func (s *server) stopPushgateway(del bool) {
+	p := s.pushgw
+	if p == nil {
+		return
+	}
+	s.pushgw = nil
+	p.cancel()
+	stopped := make(chan struct{})
+	s.pushgwStopped = stopped
+	go func() {
+		defer close(stopped)
+		// wait for an in-flight push
+		<-p.done
+		defer p.ns.Close()
+		if !del || p.grouping == nil {
+			return
+		}
+		err := p.pusher(p.grouping).Delete()
+		if err != nil {
+			log.Errorf("pushgateway: failed to delete grouping %v: %v", p.grouping, err)
+			return
+		}
+		log.Infof("pushgateway: deleted grouping %v", p.grouping)
+	}()
+}
+
+func (s *server) runPushgateway(ctx context.Context, p *pushgatewayPusher) {
+	defer close(p.done)
+	if p.prev != nil {
+		// do not push before the previous grouping is deleted
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.prev:
+		}
+	}
+	var err error
+	p.ns, err = s.networkInstanceNetNS(ctx)
+	if err != nil {
+		log.Errorf("pushgateway: failed to get the network instance namespace: %v", err)
+		return
+	}
+	p.client.Transport.(*http.Transport).DialContext = netnsDialContext(p.ns)
+	if s.identity.get() == nil {
+		// grouping key
+		s.refreshIdentity(ctx)
+	}
+	log.Infof("starting pushgateway push to %q every %s", p.cfg.URL.Value, p.interval)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("pushgateway push stopped")
+			return
+		case <-ticker.C:
+		}
+		sysInfo := s.identity.get()
+		if sysInfo == nil {
+			log.Error("pushgateway: system identity unknown, skipping push")
+			continue
+		}
+		grouping := map[string]string{
+			pushgatewayInstanceLabel:     sysInfo.Name,
+			pushgatewaySerialNumberLabel: sysInfo.ChassisSerialNumber,
+		}
+		if p.grouping != nil && !stringMapsEqual(p.grouping, grouping) {
+			// host name changed, remove the stale grouping
+			err = p.pusher(p.grouping).Delete()
+			if err != nil {
+				log.Errorf("pushgateway: failed to delete grouping %v: %v", p.grouping, err)
+			}
+		}
+		p.grouping = grouping
+		err = p.pusher(grouping).PushContext(ctx)
+		if err != nil {
+			log.Errorf("pushgateway: push to %q failed: %v", p.cfg.URL.Value, err)
+			s.metrics.pushgatewayPushes.WithLabelValues("failed").Inc()
+			continue
+		}
+		s.metrics.pushgatewayPushes.WithLabelValues("sent").Inc()
+	}
+}
+
+func stringMapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// pushgatewayGatherer adapts the gathered metrics to the Pushgateway:
+// it removes the sample timestamps set with device-timestamps, which the Pushgateway rejects,
+// and renames the series labels colliding with the job and grouping key labels to exported_<label>,
+// the whole push is rejected otherwise.
+type pushgatewayGatherer struct {
+	prometheus.Gatherer
+}
+
+func (g pushgatewayGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			m.TimestampMs = nil
+			for _, lp := range m.GetLabel() {
+				switch lp.GetName() {
+				case pushgatewayJobLabel, pushgatewayInstanceLabel, pushgatewaySerialNumberLabel:
+					name := pushgatewayExportedPrefix + lp.GetName()
+					lp.Name = &name
+				}
+			}
+		}
+	}
+	return mfs, err
+}
@@ -0,0 +1,39 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPushgatewayGatherer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "interfaces_in_octets"}, []string{"instance", "interface_name", "job", "serial_number"})
+	g.WithLabelValues("i1", "ethernet-1/1", "j1", "s1").Set(1)
+	reg.MustRegister(g)
+
+	mfs, err := pushgatewayGatherer{reg}.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mfs) != 1 || len(mfs[0].GetMetric()) != 1 {
+		t.Fatalf("unexpected metric families %v", mfs)
+	}
+	m := mfs[0].GetMetric()[0]
+	if m.TimestampMs != nil {
+		t.Errorf("expected no timestamp, got %d", m.GetTimestampMs())
+	}
+	got := make(map[string]string)
+	for _, lp := range m.GetLabel() {
+		got[lp.GetName()] = lp.GetValue()
+	}
+	want := map[string]string{
+		"exported_instance":      "i1",
+		"interface_name":         "ethernet-1/1",
+		"exported_job":           "j1",
+		"exported_serial_number": "s1",
+	}
+	if !stringMapsEqual(got, want) {
+		t.Errorf("got labels %v, expected %v", got, want)
+	}
+}
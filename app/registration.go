@@ -0,0 +1,507 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netns"
+)
+
+const (
+	// YANG enums are received as BACKEND_<value>
+	registrationBackendConsul = "BACKEND_consul"
+	registrationBackendEtcd   = "BACKEND_etcd"
+	registrationBackendFileSD = "BACKEND_file_sd"
+
+	// YANG enums are received as SERVICE_MODE_<value>
+	serviceModeMetricGroup   = "SERVICE_MODE_metric_group"
+	serviceModeScrapeProfile = "SERVICE_MODE_scrape_profile"
+
+	defaultRegistrationTTL = 5 * time.Second
+	// timeout of the deregistration, run after the registration context is canceled
+	deregisterTimeout = 5 * time.Second
+)
+
+// serviceInstance describes the exporter instance registered in the service discovery backend.
+type serviceInstance struct {
+	ID      string   `json:"id,omitempty"`
+	Name    string   `json:"name,omitempty"`
+	Address string   `json:"address,omitempty"`
+	Port    int      `json:"port,omitempty"`
+	Scheme  string   `json:"scheme,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	// identity fields and metrics path
+	Meta map[string]string `json:"meta,omitempty"`
+
+	sysInfo *systemInfo
+	// true if the exporter requires a client certificate
+	clientAuth bool
+}
+
+// serviceTemplateData is the data available to the service name and ID templates.
+type serviceTemplateData struct {
+	HostName            string
+	SoftwareVersion     string
+	ChassisType         string
+	ChassisMacAddress   string
+	ChassisPartNumber   string
+	ChassisSerialNumber string
+	NetworkInstance     string
+	Address             string
+	Port                string
+}
+
+// executeServiceTemplate executes the service name or ID template text with data,
+// def is returned if text is empty.
+func executeServiceTemplate(text, def string, data *serviceTemplateData) (string, error) {
+	if text == "" {
+		return def, nil
+	}
+	tpl, err := template.New("service").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	sb := new(strings.Builder)
+	err = tpl.Execute(sb, data)
+	if err != nil {
+		return "", err
+	}
+	v := strings.TrimSpace(sb.String())
+	if v == "" {
+		return "", fmt.Errorf("template %q returned an empty value", text)
+	}
+	return v, nil
+}
+
+// target returns the host:port scraped by Prometheus.
+func (si *serviceInstance) target() string {
+	return net.JoinHostPort(si.Address, strconv.Itoa(si.Port))
+}
+
+// forGroups returns a copy of svc scraping only the metric groups,
+// named after the metric group or scrape profile name.
+func (si *serviceInstance) forGroups(kind, name string, groups []string) *serviceInstance {
+	nsi := *si
+	nsi.ID = si.ID + "-" + name
+	nsi.Name = si.Name + "-" + name
+	nsi.Meta = make(map[string]string, len(si.Meta)+3)
+	for k, v := range si.Meta {
+		nsi.Meta[k] = v
+	}
+	params := make([]string, 0, len(groups))
+	for _, g := range groups {
+		params = append(params, collectParam+"="+url.QueryEscape(g))
+	}
+	u := url.URL{
+		Scheme:   si.Scheme,
+		Host:     si.target(),
+		Path:     si.Meta["metrics_path"],
+		RawQuery: strings.Join(params, "&"),
+	}
+	nsi.Meta[kind] = name
+	nsi.Meta["collect"] = strings.Join(groups, ",")
+	nsi.Meta["collect_url"] = u.String()
+	return &nsi
+}
+
+// registrar registers the exporter instance in a service discovery backend.
+type registrar interface {
+	// register registers svc, it is called again if the host name changes.
+	register(ctx context.Context, svc *serviceInstance) error
+	// keepalive renews the registration of svc and reports the exporter health h,
+	// it is called after register and then every TTL/2.
+	keepalive(ctx context.Context, svc *serviceInstance, h *exporterHealth) error
+	// deregister removes the registration of svc.
+	deregister(ctx context.Context, svc *serviceInstance) error
+	// close releases the backend client.
+	close() error
+}
+
+// newRegistrar returns the registrar of the configured backend,
+// the connections to the backend are established in namespace n.
+func (s *server) newRegistrar(ctx context.Context, ttl time.Duration, n netns.NsHandle) (registrar, error) {
+	cfg := s.config.baseConfig.Registration
+	switch cfg.Backend {
+	case "", registrationBackendConsul:
+		var tp *tlsProfile
+		if cfg.Consul != nil && cfg.Consul.TLSProfile.Value != "" {
+			var err error
+			tp, err = s.getTLSProfile(ctx, cfg.Consul.TLSProfile.Value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get tls-profile %q: %v", cfg.Consul.TLSProfile.Value, err)
+			}
+		}
+		return newConsulRegistrar(cfg, tp, n, s.regFailedAddr)
+	case registrationBackendEtcd:
+		return newEtcdRegistrar(cfg, ttl, n)
+	case registrationBackendFileSD:
+		return newFileSDRegistrar(cfg)
+	}
+	return nil, fmt.Errorf("unknown registration backend %q", cfg.Backend)
+}
+
+// needsNetNS returns true if the backend is reached over the network.
+func (r *registration) needsNetNS() bool {
+	return r.Backend != registrationBackendFileSD
+}
+
+// registrationChanged returns true if any of the registration leaves changed,
+// in which case a running registration is restarted.
+func registrationChanged(old, new *registration) bool {
+	if old == nil || new == nil {
+		return old != new
+	}
+	o, n := *old, *new
+	// the oper state is not configuration
+	o.OperState, n.OperState = "", ""
+	return !reflect.DeepEqual(o, n)
+}
+
+func (s *server) registrationTTL() time.Duration {
+	ttl, err := time.ParseDuration(s.config.baseConfig.Registration.TTL.Value)
+	if err != nil || ttl <= 0 {
+		return defaultRegistrationTTL
+	}
+	return ttl
+}
+
+// serviceInstance builds the registered service instance from the system information.
+func (s *server) serviceInstance(sysInfo *systemInfo) (*serviceInstance, error) {
+	addr := sysInfo.IPAddrV4
+	if addr == "" {
+		addr = sysInfo.IPAddrV6
+	}
+	port, _ := strconv.Atoi(s.config.baseConfig.Port.Value)
+	scheme := "http"
+	if s.config.baseConfig.TLSProfile.Value != "" {
+		scheme = "https"
+	}
+	tags := make([]string, 0, len(s.config.baseConfig.Registration.Tags))
+	for _, t := range s.config.baseConfig.Registration.Tags {
+		tags = append(tags, t.Value)
+	}
+	data := &serviceTemplateData{
+		HostName:            sysInfo.Name,
+		SoftwareVersion:     sysInfo.Version,
+		ChassisType:         sysInfo.ChassisType,
+		ChassisMacAddress:   sysInfo.ChassisMacAddress,
+		ChassisPartNumber:   sysInfo.ChassisPartNumber,
+		ChassisSerialNumber: sysInfo.ChassisSerialNumber,
+		NetworkInstance:     s.config.baseConfig.NetworkInstance.Value,
+		Address:             addr,
+		Port:                s.config.baseConfig.Port.Value,
+	}
+	name, err := executeServiceTemplate(s.config.baseConfig.Registration.ServiceName.Value, serviceName, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service-name: %v", err)
+	}
+	id, err := executeServiceTemplate(s.config.baseConfig.Registration.ServiceID.Value, sysInfo.Name, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service-id: %v", err)
+	}
+	meta := map[string]string{
+		"host_name":             sysInfo.Name,
+		"software_version":      sysInfo.Version,
+		"chassis_type":          sysInfo.ChassisType,
+		"chassis_mac_address":   sysInfo.ChassisMacAddress,
+		"chassis_part_number":   sysInfo.ChassisPartNumber,
+		"chassis_serial_number": sysInfo.ChassisSerialNumber,
+		"chassis_clei_code":     sysInfo.ChassisCLEICode,
+		"network_instance":      s.config.baseConfig.NetworkInstance.Value,
+		"metrics_path":          s.config.baseConfig.HttpPath.Value,
+		"scheme":                scheme,
+	}
+	for k, v := range meta {
+		if v == "" {
+			delete(meta, k)
+		}
+	}
+	return &serviceInstance{
+		ID:      id,
+		Name:    name,
+		Address: addr,
+		Port:    port,
+		Scheme:  scheme,
+		Tags:    tags,
+		Meta:    meta,
+		sysInfo: sysInfo,
+
+		clientAuth: s.clientAuthRequired(),
+	}, nil
+}
+
+func (s *server) registerService(ctx context.Context) {
+	if s.config.baseConfig.Registration.AdminState == adminDisable {
+		return
+	}
+	// stop the running registration and wait for its deregistration
+	if s.regCancelFn != nil {
+		s.regCancelFn()
+	}
+	if s.regDone != nil {
+		<-s.regDone
+	}
+	done := make(chan struct{})
+	defer close(done)
+	s.regDone = done
+
+	ctx, s.regCancelFn = context.WithCancel(ctx)
+	defer s.regCancelFn()
+
+	// set oper state to STARTING
+	s.config.baseConfig.Registration.OperState = operStarting
+	go s.updatePrometheusBaseTelemetry(ctx, s.config.baseConfig)
+
+	log.Infof("starting service registration, backend %q...", s.config.baseConfig.Registration.Backend)
+
+	n := netns.None()
+	if s.config.baseConfig.Registration.needsNetNS() {
+		for {
+			if s.config.baseConfig.Registration.AdminState == adminDisable {
+				return
+			}
+			var err error
+			n, err = s.networkInstanceNetNS(ctx)
+			if err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			log.Errorf("failed getting namespace for network-instance %q: %v", s.config.baseConfig.NetworkInstance.Value, err)
+			time.Sleep(retryInterval)
+		}
+		defer n.Close()
+		log.Infof("network instance %q netns: %s", s.config.baseConfig.NetworkInstance.Value, n.UniqueId())
+	}
+
+	for {
+		if s.config.baseConfig.Registration.AdminState == adminDisable {
+			return
+		}
+		err := s.runRegistration(ctx, n)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		log.Errorf("service registration failed: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// serviceInstances returns the service instances to register:
+// the exporter, or with the Consul backend one instance per enabled metric group or per scrape profile.
+func (s *server) serviceInstances(sysInfo *systemInfo) ([]*serviceInstance, error) {
+	svc, err := s.serviceInstance(sysInfo)
+	if err != nil {
+		return nil, err
+	}
+	regCfg := s.config.baseConfig.Registration
+	if regCfg.Backend != "" && regCfg.Backend != registrationBackendConsul || regCfg.Consul == nil {
+		return []*serviceInstance{svc}, nil
+	}
+	s.config.m.Lock()
+	defer s.config.m.Unlock()
+	enabled := s.enabledMetrics()
+	switch regCfg.Consul.ServiceMode {
+	case serviceModeMetricGroup:
+		names := make([]string, 0, len(enabled))
+		for name := range enabled {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		svcs := make([]*serviceInstance, 0, len(names))
+		for _, name := range names {
+			svcs = append(svcs, svc.forGroups("metric_group", name, []string{name}))
+		}
+		return svcs, nil
+	case serviceModeScrapeProfile:
+		names := make([]string, 0, len(s.config.scrapeProfiles))
+		for name := range s.config.scrapeProfiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		svcs := make([]*serviceInstance, 0, len(names))
+		for _, name := range names {
+			groups := make([]string, 0, len(s.config.scrapeProfiles[name].ScrapeProfile.Metric))
+			for _, m := range s.config.scrapeProfiles[name].ScrapeProfile.Metric {
+				if _, ok := enabled[m.Value]; ok {
+					groups = append(groups, m.Value)
+				}
+			}
+			if len(groups) == 0 {
+				// nothing to scrape
+				continue
+			}
+			sort.Strings(groups)
+			svcs = append(svcs, svc.forGroups("scrape_profile", name, groups))
+		}
+		return svcs, nil
+	}
+	return []*serviceInstance{svc}, nil
+}
+
+// syncRegistration triggers the registration of the services matching
+// the current metrics and scrape profiles config.
+func (s *server) syncRegistration() {
+	select {
+	case s.regSync <- struct{}{}:
+	default:
+	}
+}
+
+// runRegistration registers the exporter and keeps the registration alive
+// until the registration is disabled, ctx is canceled or an error occurs.
+func (s *server) runRegistration(ctx context.Context, n netns.NsHandle) error {
+	ttl := s.registrationTTL()
+	reg, err := s.newRegistrar(ctx, ttl, n)
+	if err != nil {
+		return fmt.Errorf("failed to create registrar: %v", err)
+	}
+	defer reg.close()
+
+	// failed records a failing consul server, tried last by the next registration,
+	// it returns false with the other backends.
+	failed := func() bool {
+		cr, ok := reg.(*consulRegistrar)
+		if ok {
+			s.regFailedAddr = cr.addr
+		}
+		return ok
+	}
+
+	// registered services per ID
+	services := make(map[string]*serviceInstance)
+	deregister := func(ctx context.Context, svc *serviceInstance) {
+		err := reg.deregister(ctx, svc)
+		if err != nil {
+			log.Errorf("failed to deregister service %q: %v", svc.ID, err)
+		}
+		delete(services, svc.ID)
+	}
+	// deregisterAll deregisters the registered services,
+	// within deregisterTimeout for all of them
+	deregisterAll := func() {
+		dctx, cancel := context.WithTimeout(context.Background(), deregisterTimeout)
+		defer cancel()
+		for _, svc := range services {
+			deregister(dctx, svc)
+		}
+	}
+	// syncServices deregisters the services that are no longer wanted or changed,
+	// and registers the new ones.
+	syncServices := func(sysInfo *systemInfo) error {
+		svcs, err := s.serviceInstances(sysInfo)
+		if err != nil {
+			return err
+		}
+		want := make(map[string]*serviceInstance, len(svcs))
+		for _, svc := range svcs {
+			want[svc.ID] = svc
+		}
+		for id, svc := range services {
+			if !reflect.DeepEqual(svc, want[id]) {
+				dctx, cancel := context.WithTimeout(context.Background(), deregisterTimeout)
+				deregister(dctx, svc)
+				cancel()
+			}
+		}
+		h := s.health()
+		for id, svc := range want {
+			if _, ok := services[id]; ok {
+				continue
+			}
+			err = reg.register(ctx, svc)
+			if err != nil {
+				return fmt.Errorf("failed to register service %q: %v", id, err)
+			}
+			services[id] = svc
+			err = reg.keepalive(ctx, svc, h)
+			if err != nil {
+				return fmt.Errorf("failed to pass the first TTL check: %v", err)
+			}
+		}
+		return nil
+	}
+
+	sysInfo, err := s.getSystemInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get system info: %v", err)
+	}
+	s.identity.set(sysInfo)
+	err = syncServices(sysInfo)
+	if err != nil {
+		failed()
+		deregisterAll()
+		return err
+	}
+	s.config.baseConfig.Registration.OperState = operUp
+	go s.updatePrometheusBaseTelemetry(ctx, s.config.baseConfig)
+
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// check if the registration was disabled since last update
+			if s.config.baseConfig.Registration.AdminState == adminDisable {
+				deregisterAll()
+				s.config.baseConfig.Registration.OperState = operDown
+				go s.updatePrometheusBaseTelemetry(ctx, s.config.baseConfig)
+				return nil
+			}
+			// check if the system identity changed since last update
+			sysInfo, err = s.getSystemInfo(ctx)
+			if err != nil {
+				deregisterAll()
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("failed to get system info: %v", err)
+			}
+			s.identity.set(sysInfo)
+			err = syncServices(sysInfo)
+			if err != nil {
+				deregisterAll()
+				return err
+			}
+			h := s.health()
+			if h.status != healthPassing {
+				log.Warnf("exporter health %s: %s", h.status, h.output)
+			}
+			for _, svc := range services {
+				err = reg.keepalive(ctx, svc, h)
+				if err != nil {
+					// re register with another consul server, the services are not deregistered
+					// from the failing one, their TTL check deregisters them
+					if !failed() {
+						deregisterAll()
+					}
+					return fmt.Errorf("failed to renew service registration: %v", err)
+				}
+			}
+		case <-s.regSync:
+			// metrics or scrape profiles changed
+			err = syncServices(sysInfo)
+			if err != nil {
+				deregisterAll()
+				return err
+			}
+		case <-ctx.Done():
+			deregisterAll()
+			return nil
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package app
+
+import "testing"
+
+func TestRegistrationChanged(t *testing.T) {
+	base := func() *registration {
+		return &registration{
+			Backend:    registrationBackendConsul,
+			Address:    stringValue{Value: "10.1.1.1:8500"},
+			TTL:        stringValue{Value: "5s"},
+			Tags:       []stringValue{{Value: "dc1"}},
+			AdminState: adminEnable,
+			OperState:  operUp,
+		}
+	}
+	tests := []struct {
+		name   string
+		modify func(r *registration)
+		want   bool
+	}{
+		{name: "unchanged", modify: func(r *registration) {}},
+		{name: "oper state", modify: func(r *registration) { r.OperState = operDown }},
+		{name: "address", modify: func(r *registration) { r.Address.Value = "10.1.1.2:8500" }, want: true},
+		{name: "ttl", modify: func(r *registration) { r.TTL.Value = "10s" }, want: true},
+		{name: "tags", modify: func(r *registration) { r.Tags = append(r.Tags, stringValue{Value: "dc2"}) }, want: true},
+		{name: "token", modify: func(r *registration) { r.Token.Value = "secret" }, want: true},
+		{name: "credentials", modify: func(r *registration) { r.Username.Value = "admin" }, want: true},
+		{name: "http check", modify: func(r *registration) { r.HTTPCheck.Value = true }, want: true},
+		{name: "consul", modify: func(r *registration) { r.Consul = &consulConfig{Datacenter: stringValue{Value: "dc1"}} }, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, new := base(), base()
+			tt.modify(new)
+			if got := registrationChanged(old, new); got != tt.want {
+				t.Errorf("registrationChanged() = %v, expected %v", got, tt.want)
+			}
+		})
+	}
+	if !registrationChanged(nil, base()) || registrationChanged(nil, nil) {
+		t.Error("unexpected registrationChanged result with nil registrations")
+	}
+}
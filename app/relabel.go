@@ -0,0 +1,208 @@
+package app
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+const (
+	relabelReplace   = "replace"
+	relabelRename    = "rename"
+	relabelKeep      = "keep"
+	relabelDrop      = "drop"
+	relabelLabelKeep = "labelkeep"
+	relabelLabelDrop = "labeldrop"
+	relabelLabelMap  = "labelmap"
+	relabelHashMod   = "hashmod"
+
+	defaultRelabelRegex       = "(.*)"
+	defaultRelabelSeparator   = ";"
+	defaultRelabelReplacement = "$1"
+)
+
+// relabelRule is a Prometheus style relabeling rule applied to the labels of each event.
+type relabelRule struct {
+	SourceLabels []string `yaml:"source-labels,omitempty"`
+	Separator    string   `yaml:"separator,omitempty"`
+	Regex        string   `yaml:"regex,omitempty"`
+	TargetLabel  string   `yaml:"target-label,omitempty"`
+	Replacement  string   `yaml:"replacement,omitempty"`
+	Modulus      uint64   `yaml:"modulus,omitempty"`
+	Action       string   `yaml:"action,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// compile validates the rule, sets its defaults and compiles its regex.
+func (r *relabelRule) compile() error {
+	// YANG enums are received as ACTION_<value>
+	r.Action = strings.ToLower(strings.TrimPrefix(r.Action, "ACTION_"))
+	if r.Action == "" {
+		r.Action = relabelReplace
+	}
+	if r.Separator == "" {
+		r.Separator = defaultRelabelSeparator
+	}
+	if r.Regex == "" {
+		r.Regex = defaultRelabelRegex
+	}
+	if r.Replacement == "" {
+		r.Replacement = defaultRelabelReplacement
+	}
+	var err error
+	r.re, err = regexp.Compile("^(?:" + r.Regex + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid regex %q: %v", r.Regex, err)
+	}
+	if r.TargetLabel != "" && !validLabelName(r.TargetLabel) {
+		return fmt.Errorf("invalid target-label %q", r.TargetLabel)
+	}
+	switch r.Action {
+	case relabelReplace, relabelRename:
+		if r.TargetLabel == "" {
+			return fmt.Errorf("action %q requires a target-label", r.Action)
+		}
+		if r.Action == relabelRename && len(r.SourceLabels) != 1 {
+			return fmt.Errorf("action %q requires exactly one source-label", r.Action)
+		}
+	case relabelHashMod:
+		if r.TargetLabel == "" || r.Modulus == 0 {
+			return fmt.Errorf("action %q requires a target-label and a non zero modulus", r.Action)
+		}
+	case relabelKeep, relabelDrop:
+		if len(r.SourceLabels) == 0 {
+			return fmt.Errorf("action %q requires source-labels", r.Action)
+		}
+	case relabelLabelKeep, relabelLabelDrop, relabelLabelMap:
+	default:
+		return fmt.Errorf("unknown relabel action %q", r.Action)
+	}
+	return nil
+}
+
+// apply applies the rule to lbls, it returns false if the series must be dropped.
+func (r *relabelRule) apply(lbls map[string]string) bool {
+	vals := make([]string, 0, len(r.SourceLabels))
+	for _, sl := range r.SourceLabels {
+		vals = append(vals, lbls[sl])
+	}
+	val := strings.Join(vals, r.Separator)
+
+	switch r.Action {
+	case relabelKeep:
+		return r.re.MatchString(val)
+	case relabelDrop:
+		return !r.re.MatchString(val)
+	case relabelRename:
+		v, ok := lbls[r.SourceLabels[0]]
+		if !ok {
+			return true
+		}
+		delete(lbls, r.SourceLabels[0])
+		lbls[r.TargetLabel] = v
+	case relabelReplace:
+		idx := r.re.FindStringSubmatchIndex(val)
+		if idx == nil {
+			return true
+		}
+		res := string(r.re.ExpandString(nil, r.Replacement, val, idx))
+		if res == "" {
+			delete(lbls, r.TargetLabel)
+			return true
+		}
+		lbls[r.TargetLabel] = res
+	case relabelHashMod:
+		sum := md5.Sum([]byte(val))
+		mod := binary.BigEndian.Uint64(sum[8:]) % r.Modulus
+		lbls[r.TargetLabel] = strconv.FormatUint(mod, 10)
+	case relabelLabelMap:
+		// the new labels are added after the loop,
+		// so that they are not matched again
+		mapped := make(map[string]string)
+		for name, v := range lbls {
+			if !r.re.MatchString(name) {
+				continue
+			}
+			newName := r.re.ReplaceAllString(name, r.Replacement)
+			if !validLabelName(newName) {
+				continue
+			}
+			mapped[newName] = v
+		}
+		for name, v := range mapped {
+			lbls[name] = v
+		}
+	case relabelLabelDrop:
+		for name := range lbls {
+			if r.re.MatchString(name) {
+				delete(lbls, name)
+			}
+		}
+	case relabelLabelKeep:
+		for name := range lbls {
+			if !r.re.MatchString(name) {
+				delete(lbls, name)
+			}
+		}
+	}
+	return true
+}
+
+// validLabelName returns true if name is a valid, non reserved, Prometheus label name.
+func validLabelName(name string) bool {
+	return model.LabelName(name).IsValid() && !strings.HasPrefix(name, model.ReservedLabelPrefix)
+}
+
+// relabel applies rules in order to the label names and values.
+// It returns the new label names and values sorted by name,
+// the returned bool is false if the series must be dropped.
+func relabel(rules []*relabelRule, labels, values []string) ([]string, []string, bool) {
+	lbls := make(map[string]string, len(labels))
+	for i, l := range labels {
+		lbls[l] = values[i]
+	}
+	for _, r := range rules {
+		if !r.apply(lbls) {
+			return nil, nil, false
+		}
+	}
+	labels = make([]string, 0, len(lbls))
+	for l := range lbls {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	values = make([]string, 0, len(labels))
+	for _, l := range labels {
+		values = append(values, lbls[l])
+	}
+	return labels, values, true
+}
+
+// relabelRules returns the relabel rules of metric name,
+// the rules from the configuration file are applied first,
+// followed by the custom metric rules ordered by index.
+// assumes config is already locked
+func (s *server) relabelRules(name string) []*relabelRule {
+	var rules []*relabelRule
+	if mo, ok := s.config.metricOptions[name]; ok && mo != nil {
+		rules = append(rules, mo.Relabel...)
+	}
+	if cm, ok := s.config.customMetric[name]; ok && len(cm.relabelRules) > 0 {
+		indexes := make([]int, 0, len(cm.relabelRules))
+		for idx := range cm.relabelRules {
+			indexes = append(indexes, idx)
+		}
+		sort.Ints(indexes)
+		for _, idx := range indexes {
+			rules = append(rules, cm.relabelRules[idx])
+		}
+	}
+	return rules
+}
@@ -0,0 +1,173 @@
+package app
+
+import (
+	"testing"
+)
+
+func TestRelabel(t *testing.T) {
+	tests := []struct {
+		name   string
+		rules  []*relabelRule
+		labels map[string]string
+		want   map[string]string
+		// false if the series is expected to be dropped
+		keep bool
+	}{
+		{
+			name: "hashmod",
+			rules: []*relabelRule{
+				{SourceLabels: []string{"interface_name"}, TargetLabel: "shard", Modulus: 4, Action: "ACTION_hashmod"},
+			},
+			labels: map[string]string{"interface_name": "ethernet-1/1"},
+			want:   map[string]string{"interface_name": "ethernet-1/1", "shard": "3"},
+			keep:   true,
+		},
+		{
+			name: "hashmod of another value",
+			rules: []*relabelRule{
+				{SourceLabels: []string{"interface_name"}, TargetLabel: "shard", Modulus: 4, Action: "hashmod"},
+			},
+			labels: map[string]string{"interface_name": "ethernet-1/2"},
+			want:   map[string]string{"interface_name": "ethernet-1/2", "shard": "0"},
+			keep:   true,
+		},
+		{
+			name: "hashmod of joined source labels",
+			rules: []*relabelRule{
+				{SourceLabels: []string{"source", "interface_name"}, TargetLabel: "shard", Modulus: 16, Action: "hashmod"},
+			},
+			labels: map[string]string{"source": "srl1", "interface_name": "ethernet-1/1"},
+			want:   map[string]string{"source": "srl1", "interface_name": "ethernet-1/1", "shard": "14"},
+			keep:   true,
+		},
+		{
+			name: "hashmod then keep a shard",
+			rules: []*relabelRule{
+				{SourceLabels: []string{"interface_name"}, TargetLabel: "shard", Modulus: 4, Action: "hashmod"},
+				{SourceLabels: []string{"shard"}, Regex: "0", Action: "keep"},
+			},
+			labels: map[string]string{"interface_name": "ethernet-1/1"},
+			keep:   false,
+		},
+		{
+			name: "labelmap",
+			rules: []*relabelRule{
+				{Regex: "subinterface_(.+)", Replacement: "sub_$1", Action: "ACTION_labelmap"},
+			},
+			labels: map[string]string{"interface_name": "ethernet-1/1", "subinterface_index": "0"},
+			want:   map[string]string{"interface_name": "ethernet-1/1", "subinterface_index": "0", "sub_index": "0"},
+			keep:   true,
+		},
+		{
+			name: "labelmap does not match the mapped labels again",
+			rules: []*relabelRule{
+				{Regex: "(.+)_name", Replacement: "${1}_name_name", Action: "labelmap"},
+			},
+			labels: map[string]string{"interface_name": "mgmt0"},
+			want:   map[string]string{"interface_name": "mgmt0", "interface_name_name": "mgmt0"},
+			keep:   true,
+		},
+		{
+			name: "labelmap skips invalid label names",
+			rules: []*relabelRule{
+				{Regex: "interface_(.+)", Replacement: "__$1", Action: "labelmap"},
+			},
+			labels: map[string]string{"interface_name": "mgmt0"},
+			want:   map[string]string{"interface_name": "mgmt0"},
+			keep:   true,
+		},
+		{
+			name: "labelmap then labeldrop",
+			rules: []*relabelRule{
+				{Regex: "interface_(.+)", Replacement: "if_$1", Action: "labelmap"},
+				{Regex: "interface_.+", Action: "labeldrop"},
+			},
+			labels: map[string]string{"interface_name": "mgmt0", "source": "srl1"},
+			want:   map[string]string{"if_name": "mgmt0", "source": "srl1"},
+			keep:   true,
+		},
+		{
+			name: "replace with empty result deletes the target",
+			rules: []*relabelRule{
+				{SourceLabels: []string{"missing"}, TargetLabel: "source", Action: "replace"},
+			},
+			labels: map[string]string{"source": "srl1"},
+			want:   map[string]string{},
+			keep:   true,
+		},
+		{
+			name: "drop",
+			rules: []*relabelRule{
+				{SourceLabels: []string{"interface_name"}, Regex: "mgmt.*", Action: "drop"},
+			},
+			labels: map[string]string{"interface_name": "mgmt0"},
+			keep:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, r := range tt.rules {
+				if err := r.compile(); err != nil {
+					t.Fatalf("failed to compile rule %+v: %v", r, err)
+				}
+			}
+			labels := make([]string, 0, len(tt.labels))
+			values := make([]string, 0, len(tt.labels))
+			for k, v := range tt.labels {
+				labels = append(labels, k)
+				values = append(values, v)
+			}
+			labels, values, keep := relabel(tt.rules, labels, values)
+			if keep != tt.keep {
+				t.Fatalf("got keep=%v, expected %v", keep, tt.keep)
+			}
+			if !keep {
+				return
+			}
+			got := labelsMap(labels, values)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got labels %v, expected %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Fatalf("got labels %v, expected %v", got, tt.want)
+				}
+			}
+			for i := 1; i < len(labels); i++ {
+				if labels[i-1] > labels[i] {
+					t.Errorf("labels not sorted: %v", labels)
+				}
+			}
+		})
+	}
+}
+
+func TestRelabelRuleCompile(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    *relabelRule
+		wantErr bool
+	}{
+		{name: "default action is replace", rule: &relabelRule{TargetLabel: "a"}},
+		{name: "replace without target", rule: &relabelRule{Action: "replace"}, wantErr: true},
+		{name: "hashmod without modulus", rule: &relabelRule{TargetLabel: "shard", Action: "hashmod"}, wantErr: true},
+		{name: "hashmod without target", rule: &relabelRule{Modulus: 2, Action: "hashmod"}, wantErr: true},
+		{name: "labelmap without source labels", rule: &relabelRule{Regex: "a_(.*)", Action: "labelmap"}},
+		{name: "rename with two source labels", rule: &relabelRule{SourceLabels: []string{"a", "b"}, TargetLabel: "c", Action: "rename"}, wantErr: true},
+		{name: "keep without source labels", rule: &relabelRule{Action: "keep"}, wantErr: true},
+		{name: "invalid regex", rule: &relabelRule{Regex: "(a", Action: "labeldrop"}, wantErr: true},
+		{name: "reserved target label", rule: &relabelRule{TargetLabel: "__name__"}, wantErr: true},
+		{name: "unknown action", rule: &relabelRule{Action: "ACTION_foo"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.compile()
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected an error compiling %+v", tt.rule)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,457 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/nokia/srlinux-ndk-go/ndk"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	defaultRemoteWriteInterval          = 30 * time.Second
+	defaultRemoteWriteTimeout           = 10 * time.Second
+	defaultRemoteWriteQueueSize         = 100
+	defaultRemoteWriteMaxSamplesPerSend = 2000
+
+	remoteWriteMinBackoff = 500 * time.Millisecond
+	remoteWriteMaxBackoff = 30 * time.Second
+)
+
+type remoteWrite struct {
+	AdminState        string      `json:"admin_state,omitempty"`
+	Interval          stringValue `json:"interval,omitempty"`
+	QueueSize         uint32Value `json:"queue_size,omitempty"`
+	MaxSamplesPerSend uint32Value `json:"max_samples_per_send,omitempty"`
+}
+
+type remoteWriteEndpointConfig struct {
+	Endpoint struct {
+		URL         stringValue `json:"url,omitempty"`
+		Username    stringValue `json:"username,omitempty"`
+		Password    secretValue `json:"password,omitempty"`
+		BearerToken secretValue `json:"bearer_token,omitempty"`
+		Timeout     stringValue `json:"timeout,omitempty"`
+		SkipVerify  boolValue   `json:"skip_verify,omitempty"`
+	} `json:"endpoint,omitempty"`
+}
+
+func (s *server) remoteWriteEnabled() bool {
+	return s.config.baseConfig.RemoteWrite != nil && s.config.baseConfig.RemoteWrite.AdminState == adminEnable
+}
+
+type promLabel struct {
+	name  string
+	value string
+}
+
+type promSample struct {
+	value     float64
+	timestamp int64
+}
+
+// timeSeries is a remote write time series, with its labels sorted by name.
+type timeSeries struct {
+	labels  []promLabel
+	samples []promSample
+}
+
+// remoteWriteQueue is a bounded in-memory queue of write requests,
+// the oldest request is dropped when the queue is full.
+type remoteWriteQueue struct {
+	m      *sync.Mutex
+	items  [][]*timeSeries
+	size   int
+	notify chan struct{}
+}
+
+func newRemoteWriteQueue(size int) *remoteWriteQueue {
+	return &remoteWriteQueue{
+		m:      new(sync.Mutex),
+		size:   size,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// push adds a request to the queue, it returns the number of samples dropped.
+func (q *remoteWriteQueue) push(req []*timeSeries) int {
+	q.m.Lock()
+	defer q.m.Unlock()
+	var dropped int
+	if len(q.items) >= q.size {
+		dropped = len(q.items[0])
+		q.items = q.items[1:]
+	}
+	q.items = append(q.items, req)
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return dropped
+}
+
+func (q *remoteWriteQueue) pop(ctx context.Context) ([]*timeSeries, bool) {
+	for {
+		q.m.Lock()
+		if len(q.items) > 0 {
+			req := q.items[0]
+			q.items = q.items[1:]
+			q.m.Unlock()
+			return req, true
+		}
+		q.m.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-q.notify:
+		}
+	}
+}
+
+func (q *remoteWriteQueue) len() int {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return len(q.items)
+}
+
+type remoteWriteEndpoint struct {
+	name        string
+	url         string
+	username    string
+	password    string
+	bearerToken string
+	client      *http.Client
+	queue       *remoteWriteQueue
+}
+
+// startRemoteWrite starts pushing the collected metrics to the configured remote write endpoints.
+func (s *server) startRemoteWrite(ctx context.Context) {
+	s.config.m.Lock()
+	defer s.config.m.Unlock()
+	s.startRemoteWriteLocked(ctx)
+}
+
+// assumes config is already locked
+func (s *server) startRemoteWriteLocked(ctx context.Context) {
+	s.stopRemoteWrite()
+	if !s.remoteWriteEnabled() || s.registry == nil {
+		return
+	}
+	rw := s.config.baseConfig.RemoteWrite
+	interval := defaultRemoteWriteInterval
+	if rw.Interval.Value != "" {
+		d, err := time.ParseDuration(rw.Interval.Value)
+		if err != nil || d <= 0 {
+			log.Errorf("invalid remote-write interval %q", rw.Interval.Value)
+		} else {
+			interval = d
+		}
+	}
+	queueSize := defaultRemoteWriteQueueSize
+	if rw.QueueSize.Value > 0 {
+		queueSize = int(rw.QueueSize.Value)
+	}
+	maxSamples := defaultRemoteWriteMaxSamplesPerSend
+	if rw.MaxSamplesPerSend.Value > 0 {
+		maxSamples = int(rw.MaxSamplesPerSend.Value)
+	}
+	endpoints := make([]*remoteWriteEndpoint, 0, len(s.config.remoteWriteEndpoints))
+	for name, epc := range s.config.remoteWriteEndpoints {
+		ep := epc.Endpoint
+		if ep.URL.Value == "" {
+			log.Errorf("remote-write endpoint %q: missing url", name)
+			continue
+		}
+		timeout := defaultRemoteWriteTimeout
+		if ep.Timeout.Value != "" {
+			d, err := time.ParseDuration(ep.Timeout.Value)
+			if err != nil || d <= 0 {
+				log.Errorf("remote-write endpoint %q: invalid timeout %q", name, ep.Timeout.Value)
+			} else {
+				timeout = d
+			}
+		}
+		endpoints = append(endpoints, &remoteWriteEndpoint{
+			name:        name,
+			url:         ep.URL.Value,
+			username:    ep.Username.Value,
+			password:    ep.Password.Value,
+			bearerToken: ep.BearerToken.Value,
+			client: &http.Client{
+				Timeout: timeout,
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: ep.SkipVerify.Value},
+				},
+			},
+			queue: newRemoteWriteQueue(queueSize),
+		})
+	}
+	if len(endpoints) == 0 {
+		log.Warn("remote-write is enabled without endpoints")
+		return
+	}
+	registry := s.registry
+	ctx, s.rwCancelFn = context.WithCancel(ctx)
+	go s.runRemoteWrite(ctx, registry, endpoints, interval, maxSamples)
+}
+
+func (s *server) stopRemoteWrite() {
+	if s.rwCancelFn != nil {
+		s.rwCancelFn()
+		s.rwCancelFn = nil
+	}
+}
+
+func (s *server) runRemoteWrite(ctx context.Context, gatherer prometheus.Gatherer, endpoints []*remoteWriteEndpoint, interval time.Duration, maxSamples int) {
+	n, err := s.networkInstanceNetNS(ctx)
+	if err != nil {
+		log.Errorf("remote-write: failed to get the network instance namespace: %v", err)
+		return
+	}
+	defer n.Close()
+	dial := netnsDialContext(n)
+	for _, ep := range endpoints {
+		ep.client.Transport.(*http.Transport).DialContext = dial
+		go s.runRemoteWriteEndpoint(ctx, ep)
+	}
+	log.Infof("starting remote-write to %d endpoint(s) every %s", len(endpoints), interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("remote-write stopped")
+			return
+		case <-ticker.C:
+		}
+		mfs, err := gatherer.Gather()
+		if err != nil {
+			// partial results are still pushed
+			log.Errorf("remote-write: gather error: %v", err)
+		}
+		series := metricFamiliesToTimeSeries(mfs, time.Now())
+		for start := 0; start < len(series); {
+			end := start
+			var samples int
+			for end < len(series) && (samples == 0 || samples+len(series[end].samples) <= maxSamples) {
+				samples += len(series[end].samples)
+				end++
+			}
+			for _, ep := range endpoints {
+				if dropped := ep.queue.push(series[start:end]); dropped > 0 {
+					log.Warnf("remote-write endpoint %q: queue full, dropped %d samples", ep.name, dropped)
+					s.metrics.remoteWriteSamples.WithLabelValues(ep.name, "dropped").Add(float64(dropped))
+				}
+			}
+			start = end
+		}
+		for _, ep := range endpoints {
+			s.metrics.remoteWriteQueueLength.WithLabelValues(ep.name).Set(float64(ep.queue.len()))
+		}
+	}
+}
+
+// runRemoteWriteEndpoint sends the queued requests to ep,
+// failed requests are retried with an exponential backoff,
+// unless the endpoint rejected them with a 4xx status code.
+func (s *server) runRemoteWriteEndpoint(ctx context.Context, ep *remoteWriteEndpoint) {
+	for {
+		req, ok := ep.queue.pop(ctx)
+		if !ok {
+			return
+		}
+		samples := 0
+		for _, ts := range req {
+			samples += len(ts.samples)
+		}
+		body := snappy.Encode(nil, encodeWriteRequest(req))
+		backoff := remoteWriteMinBackoff
+		for {
+			retry, err := ep.send(ctx, body)
+			if err == nil {
+				s.metrics.remoteWriteSamples.WithLabelValues(ep.name, "sent").Add(float64(samples))
+				break
+			}
+			log.Errorf("remote-write endpoint %q: %v", ep.name, err)
+			if !retry {
+				s.metrics.remoteWriteSamples.WithLabelValues(ep.name, "failed").Add(float64(samples))
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > remoteWriteMaxBackoff {
+				backoff = remoteWriteMaxBackoff
+			}
+		}
+	}
+}
+
+// send posts a snappy compressed write request,
+// the returned bool is true if the request can be retried.
+func (ep *remoteWriteEndpoint) send(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", serviceName)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if ep.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+ep.bearerToken)
+	} else if ep.username != "" {
+		req.SetBasicAuth(ep.username, ep.password)
+	}
+	rsp, err := ep.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, rsp.Body)
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(rsp.Body, 512))
+	err = fmt.Errorf("server returned HTTP status %s: %s", rsp.Status, bytes.TrimSpace(msg))
+	return rsp.StatusCode/100 == 5 || rsp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// metricFamiliesToTimeSeries converts the gathered metric families to remote write time series,
+// histograms and summaries are converted to their classic series.
+func metricFamiliesToTimeSeries(mfs []*dto.MetricFamily, now time.Time) []*timeSeries {
+	series := make([]*timeSeries, 0, len(mfs))
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			ts := now.UnixMilli()
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			add := func(suffix string, v float64, extra ...promLabel) {
+				series = append(series, newTimeSeries(mf.GetName()+suffix, m.GetLabel(), v, ts, extra...))
+			}
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.GetBucket() {
+					add("_bucket", float64(b.GetCumulativeCount()), promLabel{"le", formatFloat(b.GetUpperBound())})
+				}
+				add("_bucket", float64(h.GetSampleCount()), promLabel{"le", "+Inf"})
+				add("_sum", h.GetSampleSum())
+				add("_count", float64(h.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				sm := m.GetSummary()
+				for _, q := range sm.GetQuantile() {
+					add("", q.GetValue(), promLabel{"quantile", formatFloat(q.GetQuantile())})
+				}
+				add("_sum", sm.GetSampleSum())
+				add("_count", float64(sm.GetSampleCount()))
+			}
+		}
+	}
+	return series
+}
+
+func newTimeSeries(name string, lps []*dto.LabelPair, v float64, ts int64, extra ...promLabel) *timeSeries {
+	labels := make([]promLabel, 0, len(lps)+len(extra)+1)
+	labels = append(labels, promLabel{"__name__", name})
+	for _, lp := range lps {
+		labels = append(labels, promLabel{lp.GetName(), lp.GetValue()})
+	}
+	labels = append(labels, extra...)
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+	return &timeSeries{
+		labels:  labels,
+		samples: []promSample{{value: v, timestamp: ts}},
+	}
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeWriteRequest encodes the time series as a prometheus.WriteRequest protobuf message.
+func encodeWriteRequest(series []*timeSeries) []byte {
+	var b []byte
+	for _, ts := range series {
+		var tsb []byte
+		for _, l := range ts.labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.value)
+			tsb = protowire.AppendTag(tsb, 1, protowire.BytesType)
+			tsb = protowire.AppendBytes(tsb, lb)
+		}
+		for _, smpl := range ts.samples {
+			var sb []byte
+			sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+			sb = protowire.AppendFixed64(sb, math.Float64bits(smpl.value))
+			sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+			sb = protowire.AppendVarint(sb, uint64(smpl.timestamp))
+			tsb = protowire.AppendTag(tsb, 2, protowire.BytesType)
+			tsb = protowire.AppendBytes(tsb, sb)
+		}
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, tsb)
+	}
+	return b
+}
+
+func (s *server) handleCfgRemoteWriteEndpointCreateChange(ctx context.Context, cfg *ndk.ConfigNotification) {
+	name := cfg.Key.Keys[0]
+	newEndpointConfig := new(remoteWriteEndpointConfig)
+	err := json.Unmarshal([]byte(cfg.GetData().GetJson()), newEndpointConfig)
+	if err != nil {
+		log.Errorf("failed to marshal config data from path %s: %v", cfg.Key.JsPath, err)
+		return
+	}
+	s.config.remoteWriteEndpoints[name] = newEndpointConfig
+	s.updateRemoteWriteEndpointTelemetry(ctx, name, newEndpointConfig)
+	s.restartRemoteWrite(ctx)
+}
+
+func (s *server) handleCfgRemoteWriteEndpointDelete(ctx context.Context, cfg *ndk.ConfigNotification) {
+	name := cfg.Key.Keys[0]
+	delete(s.config.remoteWriteEndpoints, name)
+	s.deleteRemoteWriteEndpointTelemetry(ctx, name)
+	s.restartRemoteWrite(ctx)
+}
+
+// restartRemoteWrite restarts remote write if it is running,
+// to apply an endpoint change.
+// assumes config is already locked
+func (s *server) restartRemoteWrite(ctx context.Context) {
+	if s.config.baseConfig.OperState != operUp {
+		return
+	}
+	s.startRemoteWriteLocked(ctx)
+}
@@ -0,0 +1,163 @@
+package app
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// decodedSeries is a time series decoded from a WriteRequest.
+type decodedSeries struct {
+	labels  []promLabel
+	samples []promSample
+}
+
+// consumeFields calls fn with the field number, type and raw value of each field of b.
+func consumeFields(t *testing.T, b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte)) {
+	t.Helper()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			t.Fatalf("invalid value of field %d: %v", num, protowire.ParseError(n))
+		}
+		fn(num, typ, b[:n])
+		b = b[n:]
+	}
+}
+
+func bytesField(t *testing.T, typ protowire.Type, v []byte) []byte {
+	t.Helper()
+	if typ != protowire.BytesType {
+		t.Fatalf("unexpected wire type %d, expected bytes", typ)
+	}
+	b, n := protowire.ConsumeBytes(v)
+	if n < 0 {
+		t.Fatalf("invalid bytes: %v", protowire.ParseError(n))
+	}
+	return b
+}
+
+// decodeWriteRequest decodes a prometheus.WriteRequest:
+// WriteRequest{timeseries = 1}, TimeSeries{labels = 1, samples = 2},
+// Label{name = 1, value = 2} and Sample{value = 1 (double), timestamp = 2 (int64)}.
+func decodeWriteRequest(t *testing.T, b []byte) []*decodedSeries {
+	t.Helper()
+	var series []*decodedSeries
+	consumeFields(t, b, func(num protowire.Number, typ protowire.Type, v []byte) {
+		if num != 1 {
+			t.Fatalf("WriteRequest: unexpected field %d", num)
+		}
+		ds := new(decodedSeries)
+		consumeFields(t, bytesField(t, typ, v), func(num protowire.Number, typ protowire.Type, v []byte) {
+			switch num {
+			case 1:
+				var l promLabel
+				consumeFields(t, bytesField(t, typ, v), func(num protowire.Number, typ protowire.Type, v []byte) {
+					switch num {
+					case 1:
+						l.name = string(bytesField(t, typ, v))
+					case 2:
+						l.value = string(bytesField(t, typ, v))
+					default:
+						t.Fatalf("Label: unexpected field %d", num)
+					}
+				})
+				ds.labels = append(ds.labels, l)
+			case 2:
+				var smpl promSample
+				consumeFields(t, bytesField(t, typ, v), func(num protowire.Number, typ protowire.Type, v []byte) {
+					switch {
+					case num == 1 && typ == protowire.Fixed64Type:
+						f, _ := protowire.ConsumeFixed64(v)
+						smpl.value = math.Float64frombits(f)
+					case num == 2 && typ == protowire.VarintType:
+						ts, _ := protowire.ConsumeVarint(v)
+						smpl.timestamp = int64(ts)
+					default:
+						t.Fatalf("Sample: unexpected field %d, wire type %d", num, typ)
+					}
+				})
+				ds.samples = append(ds.samples, smpl)
+			default:
+				t.Fatalf("TimeSeries: unexpected field %d", num)
+			}
+		})
+		series = append(series, ds)
+	})
+	return series
+}
+
+func TestEncodeWriteRequest(t *testing.T) {
+	now := time.UnixMilli(1700000000123)
+	mfs := []*dto.MetricFamily{
+		{
+			Name: proto.String("interfaces_in_octets"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{
+				{
+					Label: []*dto.LabelPair{
+						{Name: proto.String("source"), Value: proto.String("srl1")},
+						{Name: proto.String("interface_name"), Value: proto.String("ethernet-1/1")},
+					},
+					Counter: &dto.Counter{Value: proto.Float64(42.5)},
+				},
+			},
+		},
+		{
+			Name: proto.String("srl_exporter_up"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{
+				{
+					Label:       []*dto.LabelPair{{Name: proto.String("name"), Value: proto.String("interfaces")}},
+					Gauge:       &dto.Gauge{Value: proto.Float64(1)},
+					TimestampMs: proto.Int64(1600000000000),
+				},
+			},
+		},
+	}
+	want := []*decodedSeries{
+		{
+			labels: []promLabel{
+				{"__name__", "interfaces_in_octets"},
+				{"interface_name", "ethernet-1/1"},
+				{"source", "srl1"},
+			},
+			samples: []promSample{{value: 42.5, timestamp: 1700000000123}},
+		},
+		{
+			labels: []promLabel{
+				{"__name__", "srl_exporter_up"},
+				{"name", "interfaces"},
+			},
+			samples: []promSample{{value: 1, timestamp: 1600000000000}},
+		},
+	}
+
+	got := decodeWriteRequest(t, encodeWriteRequest(metricFamiliesToTimeSeries(mfs, now)))
+	if len(got) != len(want) {
+		t.Fatalf("got %d series, expected %d", len(got), len(want))
+	}
+	for i := range want {
+		if len(got[i].labels) != len(want[i].labels) {
+			t.Fatalf("series %d: got labels %v, expected %v", i, got[i].labels, want[i].labels)
+		}
+		for j := range want[i].labels {
+			if got[i].labels[j] != want[i].labels[j] {
+				t.Errorf("series %d: got labels %v, expected %v", i, got[i].labels, want[i].labels)
+				break
+			}
+		}
+		if len(got[i].samples) != 1 || got[i].samples[0] != want[i].samples[0] {
+			t.Errorf("series %d: got samples %v, expected %v", i, got[i].samples, want[i].samples)
+		}
+	}
+}
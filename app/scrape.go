@@ -0,0 +1,67 @@
+package app
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// below the Prometheus default scrape_timeout (10s),
+	// leaving time to encode and send the response
+	defaultScrapeTimeout = 8 * time.Second
+)
+
+var upDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(selfMetricsNamespace, "", "up"),
+	"Whether the last collection of a metric group completed, 0 if it failed or returned partial results",
+	[]string{"metric"}, nil,
+)
+
+// scrapeTimeout returns the time allowed to collect metric name,
+// the per metric timeout takes precedence over the configuration file one,
+// which takes precedence over the exporter timeout.
+// If name is empty, the exporter timeout is returned.
+// assumes config is already locked
+func (s *server) scrapeTimeout(name string) time.Duration {
+	timeouts := make([]string, 0, 4)
+	if m, ok := s.config.metrics[name]; ok {
+		timeouts = append(timeouts, m.Metric.ScrapeTimeout.Value)
+	}
+	if m, ok := s.config.customMetric[name]; ok {
+		timeouts = append(timeouts, m.Metric.ScrapeTimeout.Value)
+	}
+	if mo, ok := s.config.metricOptions[name]; ok && mo != nil {
+		timeouts = append(timeouts, mo.ScrapeTimeout)
+	}
+	timeouts = append(timeouts, s.config.baseConfig.ScrapeTimeout.Value)
+	for _, t := range timeouts {
+		if t == "" {
+			continue
+		}
+		d, err := time.ParseDuration(t)
+		if err != nil || d <= 0 {
+			log.Errorf("metric %q: invalid scrape-timeout %q", name, t)
+			continue
+		}
+		return d
+	}
+	return defaultScrapeTimeout
+}
+
+// emitUp emits the up series of metric group name,
+// and records the collection outcome for the registration health check.
+func (s *server) emitUp(ch chan<- prometheus.Metric, name string, up bool) {
+	s.collections.add(up)
+	var v float64
+	if up {
+		v = 1
+	}
+	m, err := prometheus.NewConstMetric(upDesc, prometheus.GaugeValue, v, name)
+	if err != nil {
+		s.metrics.invalidSample(name, err)
+		return
+	}
+	ch <- m
+}
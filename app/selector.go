@@ -0,0 +1,278 @@
+package app
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	matchEqual     = "="
+	matchNotEqual  = "!="
+	matchRegexp    = "=~"
+	matchNotRegexp = "!~"
+
+	metricNameLabel = "__name__"
+)
+
+// labelMatcher is a PromQL style label matcher.
+type labelMatcher struct {
+	name  string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+func (lm *labelMatcher) matches(v string) bool {
+	switch lm.op {
+	case matchEqual:
+		return v == lm.value
+	case matchNotEqual:
+		return v != lm.value
+	case matchRegexp:
+		return lm.re.MatchString(v)
+	case matchNotRegexp:
+		return !lm.re.MatchString(v)
+	}
+	return false
+}
+
+// selector is a PromQL style series selector, e.g:
+// interfaces_in_octets{interface_name=~"ethernet-1/.*"}.
+// A series matches the selector if it matches all its matchers.
+type selector []*labelMatcher
+
+func (sel selector) matches(lbls map[string]string) bool {
+	for _, lm := range sel {
+		if !lm.matches(lbls[lm.name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesAny returns true if lbls matches at least one of the selectors.
+func matchesAny(sels []selector, lbls map[string]string) bool {
+	for _, sel := range sels {
+		if sel.matches(lbls) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSelector parses a series selector: an optional metric name
+// followed by a list of label matchers between curly braces.
+func parseSelector(s string) (selector, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, fmt.Errorf("empty selector")
+	}
+	var sel selector
+	name := s
+	var body string
+	if idx := strings.Index(s, "{"); idx >= 0 {
+		if !strings.HasSuffix(s, "}") {
+			return nil, fmt.Errorf("selector %q: missing closing brace", s)
+		}
+		name = strings.TrimSpace(s[:idx])
+		body = s[idx+1 : len(s)-1]
+	}
+	if name != "" {
+		sel = append(sel, &labelMatcher{name: metricNameLabel, op: matchEqual, value: name})
+	}
+	for body = strings.TrimSpace(body); body != ""; body = strings.TrimSpace(body) {
+		lm, rest, err := parseLabelMatcher(body)
+		if err != nil {
+			return nil, fmt.Errorf("selector %q: %v", s, err)
+		}
+		sel = append(sel, lm)
+		rest = strings.TrimSpace(rest)
+		if rest != "" && !strings.HasPrefix(rest, ",") {
+			return nil, fmt.Errorf("selector %q: unexpected %q", s, rest)
+		}
+		body = strings.TrimPrefix(rest, ",")
+	}
+	if len(sel) == 0 {
+		return nil, fmt.Errorf("selector %q: no matchers", s)
+	}
+	return sel, nil
+}
+
+// parseLabelMatcher parses the first label matcher of s and returns the remaining string.
+func parseLabelMatcher(s string) (*labelMatcher, string, error) {
+	i := strings.IndexAny(s, "=!")
+	if i <= 0 {
+		return nil, "", fmt.Errorf("invalid label matcher %q", s)
+	}
+	lm := &labelMatcher{name: strings.TrimSpace(s[:i])}
+	rest := s[i:]
+	for _, op := range []string{matchRegexp, matchNotRegexp, matchNotEqual, matchEqual} {
+		if strings.HasPrefix(rest, op) {
+			lm.op = op
+			rest = strings.TrimSpace(rest[len(op):])
+			break
+		}
+	}
+	if lm.op == "" || rest == "" {
+		return nil, "", fmt.Errorf("invalid label matcher %q", s)
+	}
+	quote := rest[0]
+	if quote != '"' && quote != '\'' && quote != '`' {
+		return nil, "", fmt.Errorf("label matcher %q: value must be quoted", s)
+	}
+	end := 1
+	for ; end < len(rest); end++ {
+		if rest[end] == '\\' && quote != '`' {
+			end++
+			continue
+		}
+		if rest[end] == quote {
+			break
+		}
+	}
+	if end >= len(rest) {
+		return nil, "", fmt.Errorf("label matcher %q: unterminated value", s)
+	}
+	raw := rest[:end+1]
+	switch quote {
+	case '`':
+		lm.value = raw[1 : len(raw)-1]
+	case '\'':
+		// single quoted strings use the same escapes as double quoted ones
+		v, err := strconv.Unquote(`"` + strings.ReplaceAll(strings.ReplaceAll(raw[1:len(raw)-1], `\'`, `'`), `"`, `\"`) + `"`)
+		if err != nil {
+			return nil, "", fmt.Errorf("label matcher %q: %v", s, err)
+		}
+		lm.value = v
+	default:
+		v, err := strconv.Unquote(raw)
+		if err != nil {
+			return nil, "", fmt.Errorf("label matcher %q: %v", s, err)
+		}
+		lm.value = v
+	}
+	if lm.op == matchRegexp || lm.op == matchNotRegexp {
+		var err error
+		lm.re, err = regexp.Compile("^(?:" + lm.value + ")$")
+		if err != nil {
+			return nil, "", fmt.Errorf("label matcher %q: invalid regex: %v", s, err)
+		}
+	}
+	return lm, rest[end+1:], nil
+}
+
+// selectorCache keeps the parsed configured selectors,
+// so they are parsed, and their errors logged, only once.
+var selectorCache = struct {
+	m    sync.Mutex
+	sels map[string]selector
+}{sels: make(map[string]selector)}
+
+// cachedSelectors returns the parsed selectors,
+// invalid selectors are logged and skipped.
+func cachedSelectors(ss []string) []selector {
+	if len(ss) == 0 {
+		return nil
+	}
+	selectorCache.m.Lock()
+	defer selectorCache.m.Unlock()
+	sels := make([]selector, 0, len(ss))
+	for _, s := range ss {
+		sel, ok := selectorCache.sels[s]
+		if !ok {
+			var err error
+			sel, err = parseSelector(s)
+			if err != nil {
+				log.Errorf("ignoring invalid series selector: %v", err)
+			}
+			selectorCache.sels[s] = sel
+		}
+		if sel != nil {
+			sels = append(sels, sel)
+		}
+	}
+	return sels
+}
+
+// seriesFilter selects the series emitted for a metric.
+type seriesFilter struct {
+	// selectors from the scrape request match[] parameters
+	match []selector
+	// configured include and exclude selectors
+	include []selector
+	exclude []selector
+	// true if one of the selectors matches on the metric name
+	byName bool
+}
+
+// keep returns true if the series with labels lbls must be emitted.
+func (sf *seriesFilter) keep(lbls map[string]string) bool {
+	if sf == nil {
+		return true
+	}
+	if len(sf.match) > 0 && !matchesAny(sf.match, lbls) {
+		return false
+	}
+	if len(sf.include) > 0 && !matchesAny(sf.include, lbls) {
+		return false
+	}
+	return !matchesAny(sf.exclude, lbls)
+}
+
+// seriesFilter returns the series filter of metric name for a scrape
+// with scrape filter f, nil if the series are not filtered.
+// assumes config is already locked
+func (s *server) seriesFilter(name string, f *scrapeFilter) *seriesFilter {
+	var include, exclude []string
+	if mo, ok := s.config.metricOptions[name]; ok && mo != nil {
+		include = append(include, mo.Include...)
+		exclude = append(exclude, mo.Exclude...)
+	}
+	var m *metric
+	if mc, ok := s.config.metrics[name]; ok {
+		m = &mc.Metric
+	} else if cm, ok := s.config.customMetric[name]; ok {
+		m = &cm.Metric
+	}
+	if m != nil {
+		for _, v := range m.Include {
+			include = append(include, v.Value)
+		}
+		for _, v := range m.Exclude {
+			exclude = append(exclude, v.Value)
+		}
+	}
+	sf := &seriesFilter{
+		include: cachedSelectors(include),
+		exclude: cachedSelectors(exclude),
+	}
+	if f != nil {
+		sf.match = f.match
+	}
+	if len(sf.match) == 0 && len(sf.include) == 0 && len(sf.exclude) == 0 {
+		return nil
+	}
+	for _, sels := range [][]selector{sf.match, sf.include, sf.exclude} {
+		for _, sel := range sels {
+			for _, lm := range sel {
+				if lm.name == metricNameLabel {
+					sf.byName = true
+				}
+			}
+		}
+	}
+	return sf
+}
+
+func labelsMap(labels, values []string) map[string]string {
+	lbls := make(map[string]string, len(labels)+1)
+	for i, l := range labels {
+		lbls[l] = values[i]
+	}
+	return lbls
+}
@@ -0,0 +1,175 @@
+package app
+
+import (
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantErr bool
+		// series expected to match, or not, the parsed selector
+		match   []map[string]string
+		noMatch []map[string]string
+	}{
+		{
+			name:  "metric name only",
+			in:    "interfaces_in_octets",
+			match: []map[string]string{{metricNameLabel: "interfaces_in_octets"}},
+			noMatch: []map[string]string{
+				{metricNameLabel: "interfaces_out_octets"},
+			},
+		},
+		{
+			name:  "metric name with empty matchers",
+			in:    "interfaces_in_octets{}",
+			match: []map[string]string{{metricNameLabel: "interfaces_in_octets"}},
+		},
+		{
+			name:    "empty matchers without metric name",
+			in:      "{}",
+			wantErr: true,
+		},
+		{
+			name:    "empty selector",
+			in:      "  ",
+			wantErr: true,
+		},
+		{
+			name: "equal and not equal",
+			in:   `{interface_name="ethernet-1/1", subinterface_index!="0"}`,
+			match: []map[string]string{
+				{"interface_name": "ethernet-1/1", "subinterface_index": "1"},
+				{"interface_name": "ethernet-1/1"},
+			},
+			noMatch: []map[string]string{
+				{"interface_name": "ethernet-1/1", "subinterface_index": "0"},
+				{"interface_name": "ethernet-1/2", "subinterface_index": "1"},
+			},
+		},
+		{
+			name:  "empty value matches a missing label",
+			in:    `{description=""}`,
+			match: []map[string]string{{"interface_name": "ethernet-1/1"}},
+			noMatch: []map[string]string{
+				{"interface_name": "ethernet-1/1", "description": "uplink"},
+			},
+		},
+		{
+			name:  "regex is anchored",
+			in:    `{interface_name=~"ethernet-1/1"}`,
+			match: []map[string]string{{"interface_name": "ethernet-1/1"}},
+			noMatch: []map[string]string{
+				{"interface_name": "ethernet-1/10"},
+				{"interface_name": "xethernet-1/1"},
+			},
+		},
+		{
+			name:  "regex alternation is anchored",
+			in:    `{interface_name=~"mgmt0|ethernet-1/1"}`,
+			match: []map[string]string{{"interface_name": "mgmt0"}, {"interface_name": "ethernet-1/1"}},
+			noMatch: []map[string]string{
+				{"interface_name": "mgmt0x"},
+				{"interface_name": "ethernet-1/11"},
+			},
+		},
+		{
+			name:  "negative regex is anchored",
+			in:    `{interface_name!~"ethernet-1/.*"}`,
+			match: []map[string]string{{"interface_name": "mgmt0"}, {"interface_name": "lo-ethernet-1/1"}},
+			noMatch: []map[string]string{
+				{"interface_name": "ethernet-1/1"},
+			},
+		},
+		{
+			name:  "escaped double quote",
+			in:    `{description="to \"core\""}`,
+			match: []map[string]string{{"description": `to "core"`}},
+		},
+		{
+			name:  "escaped single quote",
+			in:    `{description='it\'s "core"'}`,
+			match: []map[string]string{{"description": `it's "core"`}},
+		},
+		{
+			name:  "backquoted value is raw",
+			in:    "{description=~`to \\d+`}",
+			match: []map[string]string{{"description": "to 12"}},
+		},
+		{
+			name:  "comma inside quoted value",
+			in:    `{description="a,b", interface_name="mgmt0"}`,
+			match: []map[string]string{{"description": "a,b", "interface_name": "mgmt0"}},
+		},
+		{
+			name:  "trailing comma",
+			in:    `up{name="interfaces",}`,
+			match: []map[string]string{{metricNameLabel: "up", "name": "interfaces"}},
+		},
+		{
+			name:    "missing closing brace",
+			in:      `up{name="interfaces"`,
+			wantErr: true,
+		},
+		{
+			name:    "unquoted value",
+			in:      `{name=interfaces}`,
+			wantErr: true,
+		},
+		{
+			name:    "unterminated value",
+			in:      `{name="interfaces}`,
+			wantErr: true,
+		},
+		{
+			name:    "missing operator",
+			in:      `{name}`,
+			wantErr: true,
+		},
+		{
+			name:    "missing label name",
+			in:      `{="interfaces"}`,
+			wantErr: true,
+		},
+		{
+			name:    "missing comma",
+			in:      `{name="a" interface_name="b"}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid regex",
+			in:      `{name=~"(a"}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid escape",
+			in:      `{name="\q"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, err := parseSelector(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseSelector(%q): expected an error, got %v", tt.in, sel)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSelector(%q): unexpected error: %v", tt.in, err)
+			}
+			for _, lbls := range tt.match {
+				if !sel.matches(lbls) {
+					t.Errorf("parseSelector(%q): expected %v to match", tt.in, lbls)
+				}
+			}
+			for _, lbls := range tt.noMatch {
+				if sel.matches(lbls) {
+					t.Errorf("parseSelector(%q): expected %v not to match", tt.in, lbls)
+				}
+			}
+		})
+	}
+}
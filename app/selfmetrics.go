@@ -0,0 +1,192 @@
+package app
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	selfMetricsNamespace = "srl_exporter"
+)
+
+// exporterMetrics holds the exporter internal metrics,
+// exposed alongside the SR Linux metrics.
+type exporterMetrics struct {
+	collectionDuration *prometheus.HistogramVec
+	gnmiErrors         *prometheus.CounterVec
+	conversionErrors   *prometheus.CounterVec
+	invalidSeries      *prometheus.CounterVec
+	series             *prometheus.GaugeVec
+	samples            *prometheus.CounterVec
+	scrapesInFlight    prometheus.Gauge
+	lastSuccess        *prometheus.GaugeVec
+	// push modes
+	remoteWriteSamples     *prometheus.CounterVec
+	remoteWriteQueueLength *prometheus.GaugeVec
+	otlpExports            *prometheus.CounterVec
+	pushgatewayPushes      *prometheus.CounterVec
+	timestampFallbacks     *prometheus.CounterVec
+}
+
+func newExporterMetrics() *exporterMetrics {
+	return &exporterMetrics{
+		collectionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: selfMetricsNamespace,
+			Name:      "collection_duration_seconds",
+			Help:      "Duration of the collection of a metric group",
+			Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"metric"}),
+		gnmiErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: selfMetricsNamespace,
+			Name:      "gnmi_errors_total",
+			Help:      "Number of gNMI errors per metric group and gRPC status code",
+		}, []string{"metric", "code"}),
+		conversionErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: selfMetricsNamespace,
+			Name:      "conversion_errors_total",
+			Help:      "Number of gNMI notifications that could not be converted to events per metric group",
+		}, []string{"metric"}),
+		invalidSeries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: selfMetricsNamespace,
+			Name:      "invalid_series_total",
+			Help:      "Number of samples dropped because of an invalid metric name, label name or label value, per metric group",
+		}, []string{"metric"}),
+		series: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: selfMetricsNamespace,
+			Name:      "series",
+			Help:      "Number of series emitted by the last collection of a metric group",
+		}, []string{"metric"}),
+		samples: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: selfMetricsNamespace,
+			Name:      "samples_total",
+			Help:      "Number of samples emitted per metric group",
+		}, []string{"metric"}),
+		scrapesInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: selfMetricsNamespace,
+			Name:      "scrapes_in_flight",
+			Help:      "Number of scrapes being served",
+		}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: selfMetricsNamespace,
+			Name:      "last_successful_collection_timestamp_seconds",
+			Help:      "Unix time of the last successful collection of a metric group",
+		}, []string{"metric"}),
+		remoteWriteSamples: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: selfMetricsNamespace,
+			Name:      "remote_write_samples_total",
+			Help:      "Number of samples pushed to a remote write endpoint, per result: sent, failed or dropped",
+		}, []string{"endpoint", "result"}),
+		remoteWriteQueueLength: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: selfMetricsNamespace,
+			Name:      "remote_write_queue_length",
+			Help:      "Number of write requests waiting to be sent to a remote write endpoint",
+		}, []string{"endpoint"}),
+		otlpExports: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: selfMetricsNamespace,
+			Name:      "otlp_exports_total",
+			Help:      "Number of OTLP export requests, per result: sent or failed",
+		}, []string{"result"}),
+		pushgatewayPushes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: selfMetricsNamespace,
+			Name:      "pushgateway_pushes_total",
+			Help:      "Number of pushes to the Pushgateway, per result: sent or failed",
+		}, []string{"result"}),
+		timestampFallbacks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: selfMetricsNamespace,
+			Name:      "timestamp_fallbacks_total",
+			Help:      "Number of samples not using the gNMI notification timestamp, per reason: skew or out_of_order",
+		}, []string{"metric", "reason"}),
+	}
+}
+
+func (em *exporterMetrics) register(registry *prometheus.Registry) error {
+	for _, c := range []prometheus.Collector{
+		em.collectionDuration,
+		em.gnmiErrors,
+		em.conversionErrors,
+		em.invalidSeries,
+		em.series,
+		em.samples,
+		em.scrapesInFlight,
+		em.lastSuccess,
+		em.remoteWriteSamples,
+		em.remoteWriteQueueLength,
+		em.otlpExports,
+		em.pushgatewayPushes,
+		em.timestampFallbacks,
+	} {
+		if err := registry.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// observeCollection records the duration and output of a metric group collection.
+func (em *exporterMetrics) observeCollection(name string, start time.Time, series, samples int) {
+	em.collectionDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	em.series.WithLabelValues(name).Set(float64(series))
+	em.samples.WithLabelValues(name).Add(float64(samples))
+}
+
+// collected records a successful collection of metric group name,
+// a full ONCE subscription or a streaming update.
+func (em *exporterMetrics) collected(name string) {
+	em.lastSuccess.WithLabelValues(name).Set(float64(time.Now().UnixNano()) / float64(time.Second))
+}
+
+// invalidSample records a sample of metric group name rejected by the client library,
+// e.g because a relabel rule produced an invalid label name.
+func (em *exporterMetrics) invalidSample(name string, err error) {
+	log.Debugf("metric %q: dropping invalid sample: %v", name, err)
+	em.invalidSeries.WithLabelValues(name).Inc()
+}
+
+func (em *exporterMetrics) gnmiError(name string, err error) {
+	em.gnmiErrors.WithLabelValues(name, status.Code(err).String()).Inc()
+}
+
+// forget removes the series of a metric group that is no longer collected.
+func (em *exporterMetrics) forget(name string) {
+	em.collectionDuration.DeleteLabelValues(name)
+	em.series.DeleteLabelValues(name)
+	em.samples.DeleteLabelValues(name)
+	em.lastSuccess.DeleteLabelValues(name)
+	em.gnmiErrors.DeletePartialMatch(prometheus.Labels{"metric": name})
+	em.conversionErrors.DeleteLabelValues(name)
+	em.invalidSeries.DeleteLabelValues(name)
+	em.timestampFallbacks.DeletePartialMatch(prometheus.Labels{"metric": name})
+}
+
+// runtimeCollector exposes the Go runtime and process metrics
+// when runtime-metrics is enabled.
+type runtimeCollector struct {
+	s           *server
+	goCollector prometheus.Collector
+	process     prometheus.Collector
+}
+
+func newRuntimeCollector(s *server) *runtimeCollector {
+	return &runtimeCollector{
+		s:           s,
+		goCollector: collectors.NewGoCollector(),
+		process:     collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	}
+}
+
+// Describe implements prometheus.Collector,
+// the collector is unchecked since it can be enabled and disabled at runtime.
+func (rc *runtimeCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector
+func (rc *runtimeCollector) Collect(ch chan<- prometheus.Metric) {
+	if !rc.s.config.baseConfig.RuntimeMetrics.Value {
+		return
+	}
+	rc.goCollector.Collect(ch)
+	rc.process.Collect(ch)
+}
@@ -2,14 +2,13 @@ package app
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"math"
 	"net"
 	"net/http"
-	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
@@ -19,17 +18,14 @@ import (
 	"sync/atomic"
 	"time"
 
-	capi "github.com/hashicorp/consul/api"
 	agent "github.com/karimra/srl-ndk-demo"
 	"github.com/openconfig/gnmi/proto/gnmi"
 	"github.com/openconfig/gnmic/pkg/formatters"
 	gpath "github.com/openconfig/gnmic/pkg/path"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"github.com/vishvananda/netns"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/metadata"
 )
 
@@ -92,9 +88,47 @@ type server struct {
 	srv         *http.Server
 	srvCancelFn context.CancelFunc
 	regCancelFn context.CancelFunc
-	//
-	consulClient *capi.Client
-	metricRegex  *regexp.Regexp
+	// closed when the running registration returns
+	regDone chan struct{}
+	// signals a metric or scrape profile change to the running registration
+	regSync chan struct{}
+	// consul server of the last failed registration,
+	// the next registration starts with the following server
+	regFailedAddr string
+	metricRegex   *regexp.Regexp
+	tlsStore      *tlsStore
+	// streaming subscriptions
+	streamMu        *sync.Mutex
+	streamCancelFns map[string]context.CancelFunc
+	cache           *eventCache
+	// shared gNMI connection
+	gnmi *gnmiConn
+	// last known system information
+	identity *identityCache
+	// signals an identity labels config change to the identity watcher
+	identitySync chan struct{}
+	// exporter internal metrics
+	metrics *exporterMetrics
+	// successful basic auth verifications
+	authCache *authCache
+	// per client rate limiters
+	rateLimiters *rateLimiters
+	// rejected scrapes counters
+	rejected *rejectedScrapes
+	// registry built in start, gathered by the push modes
+	registry *prometheus.Registry
+	// remote write cancel function
+	rwCancelFn context.CancelFunc
+	// otlp export cancel function
+	otlpCancelFn context.CancelFunc
+	// running pushgateway pusher
+	pushgw *pushgatewayPusher
+	// closed once the last stopped pusher is done, and its grouping deleted
+	pushgwStopped chan struct{}
+	// last sample timestamp per series, with device timestamps
+	timestamps *timestampsTracker
+	// recent collections outcome
+	collections *collectionResults
 }
 
 type serverOption func(*server)
@@ -129,38 +163,51 @@ func (s *server) Describe(ch chan<- *prometheus.Desc) {}
 
 // Collect implements prometheus.Collector
 func (s *server) Collect(ch chan<- prometheus.Metric) {
+	s.collect(ch, nil)
+}
+
+// collect collects the enabled metrics selected by filter,
+// all of them if filter is nil.
+func (s *server) collect(ch chan<- prometheus.Metric, filter *scrapeFilter) {
 	atomic.AddUint64(&s.config.baseConfig.ScrapesCount.Value, 1)
+	s.metrics.scrapesInFlight.Inc()
+	defer s.metrics.scrapesInFlight.Dec()
 	statsCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 	statsCtx = metadata.AppendToOutgoingContext(statsCtx, "agent_name", s.config.agentName)
 	s.updatePrometheusBaseTelemetry(statsCtx, s.config.baseConfig)
 
-	gctx, gcancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer gcancel()
-
-	conn, gnmiClient, err := createGNMIClient(gctx)
-	if err != nil {
-		log.Errorf("failed to create a gnmi connection to %q: %v", gnmiServerUnixSocket, err)
+	if s.streamingEnabled() {
+		s.collectFromCache(ch, filter)
 		return
 	}
-	defer conn.Close()
 
-	// lock config
-	s.config.m.Lock()
-	defer s.config.m.Unlock()
-
-	// get metrics that are enabled
-	metrics := make(map[string]metric, len(s.config.metrics)+len(s.config.customMetric))
-	for name, m := range s.config.metrics {
-		if m.Metric.State == stateEnable {
-			metrics[name] = m.Metric
-		}
+	// copy the collections parameters under the config lock,
+	// it is released during the gNMI subscriptions, so that concurrent
+	// scrapes of other metric groups are not blocked by a slow one
+	type collection struct {
+		m       metric
+		req     *gnmi.SubscribeRequest
+		err     error
+		timeout time.Duration
+		sf      *seriesFilter
+		info    bool
 	}
-	for name, m := range s.config.customMetric {
-		if m.Metric.State == stateEnable {
-			metrics[name] = m.Metric
+	s.config.m.Lock()
+	metrics := filter.metrics(s.enabledMetrics())
+	collections := make(map[string]*collection, len(metrics))
+	for name, m := range metrics {
+		c := &collection{
+			m:       m,
+			timeout: s.scrapeTimeout(name),
+			sf:      s.seriesFilter(name, filter),
+			info:    s.isInfoMetric(name),
 		}
+		c.req, c.err = s.createSubscribeRequest(name)
+		collections[name] = c
 	}
+	clientTimeout := s.scrapeTimeout("")
+	s.config.m.Unlock()
 
 	log.Debugf("about to collect metrics: %+v", metrics)
 	ctx, cancel := context.WithCancel(context.Background())
@@ -174,18 +221,56 @@ func (s *server) Collect(ch chan<- prometheus.Metric) {
 		ctx = metadata.AppendToOutgoingContext(ctx, "password", s.config.password)
 	}
 
+	cctx, ccancel := context.WithTimeout(ctx, clientTimeout)
+	gnmiClient, err := s.gnmi.gnmiClient(cctx)
+	ccancel()
+	if err != nil {
+		log.Errorf("failed to get a gnmi client: %v", err)
+		for name := range collections {
+			s.metrics.gnmiError(name, err)
+			s.emitUp(ch, name, false)
+		}
+		return
+	}
+
+	// emit converts the events under the config lock
+	emit := func(name string, c *collection, events []*formatters.EventMsg) (int, int) {
+		s.config.m.Lock()
+		defer s.config.m.Unlock()
+		return s.emitMetrics(ch, name, c.m, events, c.sf)
+	}
+
 	wg := new(sync.WaitGroup)
-	wg.Add(len(metrics))
-	for name, m := range metrics {
-		go func(name string, m metric) {
+	wg.Add(len(collections))
+	for name, c := range collections {
+		go func(name string, c *collection) {
 			defer wg.Done()
 			log.Debugf("collecting metric %q", name)
-			req, err := s.createSubscribeRequest(name)
-			if err != nil {
+			if c.err != nil {
+				log.Errorf("failed to create subscribe request for metric %q: %v", name, c.err)
+				s.emitUp(ch, name, false)
 				return
 			}
+			start := time.Now()
+			var series, samples int
+			var err error
+			defer func() {
+				s.metrics.observeCollection(name, start, series, samples)
+				s.emitUp(ch, name, err == nil)
+				if err != nil {
+					s.metrics.gnmiError(name, err)
+					return
+				}
+				s.metrics.collected(name)
+			}()
+			var infoEvents []*formatters.EventMsg
+			if c.info {
+				// info metrics are built once all the events are received,
+				// or when the scrape timeout is reached
+				defer func() { series, samples = emit(name, c, infoEvents) }()
+			}
 
-			sctx, cancel := context.WithCancel(ctx)
+			sctx, cancel := context.WithTimeout(ctx, c.timeout)
 			defer cancel()
 			subClient, err := gnmiClient.Subscribe(sctx)
 			if err != nil {
@@ -194,53 +279,172 @@ func (s *server) Collect(ch chan<- prometheus.Metric) {
 			}
 			defer subClient.CloseSend()
 
-			log.Debugf("sending subscribe request: %+v", req)
-			err = subClient.Send(req)
+			log.Debugf("sending subscribe request: %+v", c.req)
+			err = subClient.Send(c.req)
 			if err != nil {
 				log.Errorf("failed to send a subscribe request for metric %q: %v", name, err)
 				return
 			}
 			for {
-				subResp, err := subClient.Recv()
+				var subResp *gnmi.SubscribeResponse
+				subResp, err = subClient.Recv()
 				if err == io.EOF {
 					log.Debugf("subscription for metric %q received EOF, subscription done", name)
+					err = nil
 					return
 				}
 				if err != nil {
+					if sctx.Err() == context.DeadlineExceeded {
+						log.Warnf("metric %q: scrape timeout %s reached, returning partial results", name, c.timeout)
+						return
+					}
 					log.Errorf("failed to receive a subscribe request for metric %q: %v", name, err)
 					return
 				}
 
 				log.Debugf("received subscribe response: %+v", subResp)
-				events, err := formatters.ResponseToEventMsgs("", subResp, nil)
-				if err != nil {
-					log.Errorf("failed to convert message to event: %v", err)
-					return
+				events, cerr := formatters.ResponseToEventMsgs("", subResp, nil)
+				if cerr != nil {
+					// skip this response, keep collecting the others
+					log.Errorf("metric %q: failed to convert message to event: %v", name, cerr)
+					s.metrics.conversionErrors.WithLabelValues(name).Inc()
+					continue
 				}
-				for _, ev := range events {
-					labels, values := s.getLabels(ev)
-					for vname, v := range ev.Values {
-						v, err := getFloat(v)
-						if err != nil {
-							continue
-						}
-						ch <- prometheus.MustNewConstMetric(
-							prometheus.NewDesc(s.metricName(name, vname), m.HelpText.Value, labels, nil),
-							prometheus.UntypedValue,
-							v,
-							values...)
-					}
+				if c.info {
+					infoEvents = append(infoEvents, events...)
+					continue
 				}
+				ns, nv := emit(name, c, events)
+				series += ns
+				samples += nv
 			}
-		}(name, m)
+		}(name, c)
 	}
 	wg.Wait()
 }
 
+// collectFromCache emits the metrics stored in the streaming subscriptions cache.
+func (s *server) collectFromCache(ch chan<- prometheus.Metric, filter *scrapeFilter) {
+	s.config.m.Lock()
+	defer s.config.m.Unlock()
+
+	for name, m := range filter.metrics(s.enabledMetrics()) {
+		start := time.Now()
+		series, samples := s.emitMetrics(ch, name, m, s.cache.get(name), s.seriesFilter(name, filter))
+		s.metrics.observeCollection(name, start, series, samples)
+		s.emitUp(ch, name, s.cache.healthy(name))
+	}
+}
+
+// enabledMetrics returns the predefined and custom metrics that are enabled.
+// assumes config is already locked
+func (s *server) enabledMetrics() map[string]metric {
+	metrics := make(map[string]metric, len(s.config.metrics)+len(s.config.customMetric))
+	for name, m := range s.config.metrics {
+		if m.Metric.State == stateEnable {
+			metrics[name] = m.Metric
+		}
+	}
+	for name, m := range s.config.customMetric {
+		if m.Metric.State == stateEnable {
+			metrics[name] = m.Metric
+		}
+	}
+	return metrics
+}
+
+// emitMetrics converts events of metric name into prometheus metrics,
+// the series not selected by sf are skipped.
+// It returns the number of series and samples emitted.
+// assumes config is already locked
+func (s *server) emitMetrics(ch chan<- prometheus.Metric, name string, m metric, events []*formatters.EventMsg, sf *seriesFilter) (int, int) {
+	if s.isInfoMetric(name) {
+		n := s.emitInfoMetrics(ch, name, m, events, sf)
+		return n, n
+	}
+	var series, samples int
+	rules := s.relabelRules(name)
+	metricName := s.metricNamer(name)
+	constLabels := s.constLabels(name)
+	withTimestamps, maxSkew := s.deviceTimestampsEnabled()
+	now := time.Now()
+	for _, ev := range events {
+		labels, values := s.getLabels(ev)
+		labels, values = addLabels(labels, values, constLabels)
+		if len(rules) > 0 {
+			var keep bool
+			labels, values, keep = relabel(rules, labels, values)
+			if !keep {
+				continue
+			}
+		}
+		var lbls map[string]string
+		if sf != nil {
+			lbls = labelsMap(labels, values)
+			if !sf.byName && !sf.keep(lbls) {
+				continue
+			}
+		}
+		// an event counts as a series only if at least one of its values is emitted
+		emitted := samples
+		for vname, v := range ev.Values {
+			mname := metricName(vname)
+			if sf != nil && sf.byName {
+				lbls[metricNameLabel] = mname
+				if !sf.keep(lbls) {
+					continue
+				}
+			}
+			v, err := s.convertValue(name, vname, v)
+			if err != nil {
+				continue
+			}
+			pm, err := prometheus.NewConstMetric(
+				prometheus.NewDesc(mname, m.HelpText.Value, labels, nil),
+				s.metricType(name, vname),
+				v,
+				values...)
+			if err != nil {
+				s.metrics.invalidSample(name, err)
+				continue
+			}
+			if withTimestamps {
+				ts, reason, ok := s.timestamps.sampleTime(seriesKey(mname, values), ev.Timestamp, now, maxSkew)
+				if reason != "" {
+					s.metrics.timestampFallbacks.WithLabelValues(name, reason).Inc()
+				}
+				if !ok {
+					continue
+				}
+				pm = prometheus.NewMetricWithTimestamp(ts, pm)
+			}
+			ch <- pm
+			samples++
+		}
+		if samples > emitted {
+			series++
+		}
+	}
+	return series, samples
+}
+
 func NewServer(opts ...serverOption) *server {
 	s := &server{
-		metricRegex: regexp.MustCompile(metricNameRegex),
-	}
+		metricRegex:     regexp.MustCompile(metricNameRegex),
+		streamMu:        new(sync.Mutex),
+		streamCancelFns: make(map[string]context.CancelFunc),
+		cache:           newEventCache(),
+		identity:        newIdentityCache(),
+		identitySync:    make(chan struct{}, 1),
+		metrics:         newExporterMetrics(),
+		authCache:       newAuthCache(),
+		rateLimiters:    newRateLimiters(),
+		rejected:        new(rejectedScrapes),
+		timestamps:      newTimestampsTracker(),
+		collections:     newCollectionResults(),
+		regSync:         make(chan struct{}, 1),
+	}
+	s.gnmi = newGNMIConn(s.handleGNMIStateChange)
 
 	for _, opt := range opts {
 		opt(s)
@@ -271,18 +475,28 @@ START:
 			time.Sleep(retryInterval)
 			goto START
 		}
+		err = s.metrics.register(registry)
+		if err != nil {
+			log.Errorf("failed to add exporter metrics to prometheus registry: %v", err)
+			time.Sleep(retryInterval)
+			goto START
+		}
+		rc := newRuntimeCollector(s)
+		err = registry.Register(rc)
+		if err != nil {
+			log.Errorf("failed to add runtime metrics to prometheus registry: %v", err)
+			time.Sleep(retryInterval)
+			goto START
+		}
+		s.registry = registry
 		// create http server
-		promHandler := promhttp.HandlerFor(
-			registry,
-			promhttp.HandlerOpts{
-				ErrorHandling: promhttp.ContinueOnError,
-			})
+		promHandler := s.metricsHandler(registry, rc)
 
 		mux := http.NewServeMux()
 		if s.config.baseConfig.HttpPath.Value == "" {
 			s.config.baseConfig.HttpPath.Value = "/"
 		}
-		mux.Handle(s.config.baseConfig.HttpPath.Value, promHandler)
+		mux.Handle(s.config.baseConfig.HttpPath.Value, s.withAccessControl(promHandler))
 		mux.Handle("/", new(healthHandler))
 
 		var addr string
@@ -323,6 +537,28 @@ START:
 			goto START
 		}
 
+		// load TLS profile if configured
+		var store *tlsStore
+		if tlsProfileName := s.config.baseConfig.TLSProfile.Value; tlsProfileName != "" {
+			p, err := s.getTLSProfile(sctx, tlsProfileName)
+			if err != nil {
+				log.Errorf("failed to get tls-profile %q: %v", tlsProfileName, err)
+				time.Sleep(retryInterval)
+				goto START
+			}
+			store = newTLSStore()
+			err = store.load(p)
+			if err != nil {
+				log.Errorf("failed to load tls-profile: %v", err)
+				time.Sleep(retryInterval)
+				goto START
+			}
+		}
+		// read by the config handlers and the registration
+		s.config.m.Lock()
+		s.tlsStore = store
+		s.config.m.Unlock()
+
 		// create tcp listener
 		listener, err := net.Listen("tcp", addr)
 		if err != nil {
@@ -330,6 +566,10 @@ START:
 			time.Sleep(retryInterval)
 			goto START
 		}
+		if store != nil {
+			listener = tls.NewListener(listener, store.config())
+			go s.watchTLSProfile(sctx, store)
+		}
 
 		// start http server
 		log.Infof("starting http server on %s", s.srv.Addr)
@@ -345,12 +585,19 @@ START:
 			}
 			log.Infof("http server closed...")
 		}()
+		if s.streamingEnabled() {
+			go s.startStreaming(sctx)
+		}
+		go s.watchIdentity(sctx)
+		go s.startRemoteWrite(sctx)
+		go s.startOTLP(sctx)
+		go s.startPushgateway(sctx)
 		go s.registerService(sctx)
 	}
 }
 
 // assumes config is already locked
-func (s *server) createSubscribeRequest(metricName string) (*gnmi.SubscribeRequest, error) {
+func (s *server) metricPaths(metricName string) ([]string, error) {
 	var paths []string
 	if _, ok := s.config.metrics[metricName]; ok {
 		paths = make([]string, 0, len(knownMetrics[metricName]))
@@ -363,6 +610,15 @@ func (s *server) createSubscribeRequest(metricName string) (*gnmi.SubscribeReque
 	} else {
 		return nil, fmt.Errorf("unknown metric name %s", metricName)
 	}
+	return paths, nil
+}
+
+// assumes config is already locked
+func (s *server) createSubscribeRequest(metricName string) (*gnmi.SubscribeRequest, error) {
+	paths, err := s.metricPaths(metricName)
+	if err != nil {
+		return nil, err
+	}
 	numPaths := len(paths)
 	if numPaths == 0 {
 		return nil, fmt.Errorf("no paths found under metric %q", metricName)
@@ -387,6 +643,19 @@ func (s *server) createSubscribeRequest(metricName string) (*gnmi.SubscribeReque
 }
 
 func (s *server) getLabels(ev *formatters.EventMsg) ([]string, []string) {
+	switch s.config.baseConfig.LabelNaming {
+	case labelNamingKey:
+		return s.getKeyLabels(ev, false)
+	case labelNamingQualified:
+		return s.getKeyLabels(ev, true)
+	default:
+		return s.getLegacyLabels(ev)
+	}
+}
+
+// getLegacyLabels uses the basename of each tag as label name,
+// if 2 tags have the same basename, only one of them is kept.
+func (s *server) getLegacyLabels(ev *formatters.EventMsg) ([]string, []string) {
 	labels := make([]string, 0, len(ev.Tags))
 	values := make([]string, 0, len(ev.Tags))
 	addedLabels := make(map[string]struct{})
@@ -439,16 +708,16 @@ func getFloat(v interface{}) (float64, error) {
 	}
 }
 
-func (s *server) metricName(name, valueName string) string {
-	// more customizations ?
-	valueName = fmt.Sprintf("%s_%s", name, path.Base(valueName))
-	return strings.TrimLeft(s.metricRegex.ReplaceAllString(valueName, "_"), "_")
-}
-
 func (s *server) shutdown(ctx context.Context, timeout time.Duration) {
 	cctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
+	s.stopStreaming()
+	s.stopRemoteWrite()
+	s.stopOTLP()
+	// the exporter is admin disabled or restarting, remove its metrics from the Pushgateway
+	s.stopPushgateway(true)
+
 	if s.srvCancelFn != nil {
 		// stop any running registration goroutine
 		s.srvCancelFn()
@@ -466,209 +735,6 @@ func (s *server) shutdown(ctx context.Context, timeout time.Duration) {
 	s.updatePrometheusBaseTelemetry(ctx, s.config.baseConfig)
 }
 
-func (s *server) registerService(ctx context.Context) {
-	if s.config.baseConfig.Registration.AdminState == adminDisable {
-		return
-	}
-	if s.regCancelFn != nil {
-		s.regCancelFn()
-	}
-
-	ctx, s.regCancelFn = context.WithCancel(ctx)
-	defer s.regCancelFn()
-
-	// set oper state to STARTING
-	s.config.baseConfig.Registration.OperState = operStarting
-	go s.updatePrometheusBaseTelemetry(ctx, s.config.baseConfig)
-
-	log.Info("starting service registration...")
-
-NETNS:
-	if s.config.baseConfig.Registration.AdminState == adminDisable {
-		return
-	}
-	// get network instance corresponding netns
-	var netInstName string
-	for {
-		if netInst, ok := s.config.nwInst[s.config.baseConfig.NetworkInstance.Value]; ok {
-			netInstName = fmt.Sprintf("%s-%s", netInst.BaseName, s.config.baseConfig.NetworkInstance.Value)
-			break
-		} else {
-			log.Errorf("unknown network instance name: %s", s.config.baseConfig.NetworkInstance.Value)
-			time.Sleep(time.Second)
-		}
-	}
-	log.Infof("using network-instance name %q", netInstName)
-
-	n, err := netns.GetFromName(netInstName)
-	if err != nil {
-		log.Errorf("failed getting namespace for network-instance %q: %v", netInstName, err)
-		time.Sleep(retryInterval)
-		goto NETNS
-	}
-	defer n.Close()
-	log.Infof("network instance %q netns: %s", netInstName, n.UniqueId())
-
-INITCONSUL:
-	if s.config.baseConfig.Registration.AdminState == adminDisable {
-		return
-	}
-	trans := &http.Transport{
-		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
-			runtime.LockOSThread()
-			err := netns.Set(n)
-			if err != nil {
-				return nil, fmt.Errorf("failed to set NetNS %q: %v", n.UniqueId(), err)
-			}
-			log.Infof("switched to netNS: %s", n.UniqueId())
-			return (&net.Dialer{
-				Timeout:   5 * time.Second,
-				KeepAlive: 5 * time.Second,
-				DualStack: true,
-			}).DialContext(ctx, network, address)
-		},
-	}
-	defer runtime.UnlockOSThread()
-
-	clientConfig := &capi.Config{
-		Address:   s.config.baseConfig.Registration.Address.Value,
-		Scheme:    "http",
-		Token:     s.config.baseConfig.Registration.Token.Value,
-		Transport: trans,
-	}
-	if s.config.baseConfig.Registration.Username.Value != "" && s.config.baseConfig.Registration.Password.Value != "" {
-		clientConfig.HttpAuth = &capi.HttpBasicAuth{
-			Username: s.config.baseConfig.Registration.Username.Value,
-			Password: s.config.baseConfig.Registration.Password.Value,
-		}
-	}
-	s.consulClient, err = capi.NewClient(clientConfig)
-	if err != nil {
-		log.Errorf("failed to create Consul client: %v", err)
-		time.Sleep(retryInterval)
-		goto INITCONSUL
-	}
-	self, err := s.consulClient.Agent().Self()
-	if err != nil {
-		log.Errorf("failed to get Consul Agent details: %v", err)
-		time.Sleep(retryInterval)
-		goto INITCONSUL
-	}
-	if cfg, ok := self["Config"]; ok {
-		b, _ := json.Marshal(cfg)
-		log.Infof("consul agent config: %s", string(b))
-	}
-
-	systemInfo, err := s.getSystemInfo(ctx)
-	if err != nil {
-		log.Errorf("failed to connect to consul: %v", err)
-		time.Sleep(retryInterval)
-		goto INITCONSUL
-	}
-	addr := systemInfo.IPAddrV4
-	if addr == "" {
-		addr = systemInfo.IPAddrV6
-	}
-
-	port, _ := strconv.Atoi(s.config.baseConfig.Port.Value)
-
-	tags := make([]string, 0, len(s.config.baseConfig.Registration.Tags)+6)
-	for _, t := range s.config.baseConfig.Registration.Tags {
-		tags = append(tags, t.Value)
-	}
-	tags = append(tags,
-		fmt.Sprintf("version=%s", systemInfo.Version),
-		fmt.Sprintf("chassis-type=%s", systemInfo.ChassisType),
-		fmt.Sprintf("chassis-mac-address=%s", systemInfo.ChassisMacAddress),
-		fmt.Sprintf("chassis-part-number=%s", systemInfo.ChassisPartNumber),
-		fmt.Sprintf("chassis-serial-number=%s", systemInfo.ChassisSerialNumber),
-		fmt.Sprintf("chassis-clei-code=%s", systemInfo.ChassisCLEICode),
-	)
-
-	service := &capi.AgentServiceRegistration{
-		ID:      systemInfo.Name,
-		Name:    serviceName,
-		Address: addr,
-		Port:    port,
-		Tags:    tags,
-		Checks: capi.AgentServiceChecks{
-			{
-				TTL:                            s.config.baseConfig.Registration.TTL.Value,
-				DeregisterCriticalServiceAfter: s.config.baseConfig.Registration.TTL.Value,
-			},
-		},
-	}
-
-	ttlCheckID := "service:" + systemInfo.Name
-	if s.config.baseConfig.Registration.HTTPCheck.Value {
-		service.Checks = append(service.Checks, &capi.AgentServiceCheck{
-			HTTP:                           fmt.Sprintf("http://%s:%s", addr, s.config.baseConfig.Port.Value),
-			Method:                         "GET",
-			Interval:                       s.config.baseConfig.Registration.TTL.Value,
-			TLSSkipVerify:                  true,
-			DeregisterCriticalServiceAfter: s.config.baseConfig.Registration.TTL.Value,
-		})
-		ttlCheckID = ttlCheckID + ":1"
-	}
-	b, _ := json.Marshal(service)
-	log.Infof("registering service: %s", string(b))
-	err = s.consulClient.Agent().ServiceRegister(service)
-	if err != nil {
-		log.Errorf("failed to register service in consul: %v", err)
-		time.Sleep(retryInterval)
-		goto INITCONSUL
-	}
-	s.config.baseConfig.Registration.OperState = operUp
-	go s.updatePrometheusBaseTelemetry(ctx, s.config.baseConfig)
-
-	err = s.consulClient.Agent().UpdateTTL(ttlCheckID, "", capi.HealthPassing)
-	if err != nil {
-		log.Errorf("failed to pass the first TTL check: %v", err)
-		time.Sleep(retryInterval)
-		goto INITCONSUL
-	}
-
-	ttl, _ := time.ParseDuration(s.config.baseConfig.Registration.TTL.Value)
-	ticker := time.NewTicker(ttl / 2)
-
-	for {
-		select {
-		case <-ticker.C:
-			// check if the registration was disabled since last update
-			if s.config.baseConfig.Registration.AdminState == adminDisable {
-				s.consulClient.Agent().ServiceDeregister(systemInfo.Name)
-				s.config.baseConfig.Registration.OperState = operDown
-				go s.updatePrometheusBaseTelemetry(ctx, s.config.baseConfig)
-				ticker.Stop()
-				return
-			}
-			// check if systemName was changed since last update
-			newSysInfo, err := s.getSystemInfo(ctx)
-			if err != nil {
-				// failed to get systemName: deregister, recreate Consul client and re register
-				s.consulClient.Agent().ServiceDeregister(systemInfo.Name)
-				ticker.Stop()
-				goto INITCONSUL
-			}
-			// systemName changed: deregister, recreate Consul client and re register
-			if systemInfo.Name != newSysInfo.Name {
-				ticker.Stop()
-				s.consulClient.Agent().ServiceDeregister(systemInfo.Name)
-				goto INITCONSUL
-			}
-			// no change in systemName: update Service TTL
-			err = s.consulClient.Agent().UpdateTTL(ttlCheckID, "", capi.HealthPassing)
-			if err != nil {
-				log.Errorf("failed to pass TTL check: %v", err)
-			}
-		case <-ctx.Done():
-			s.consulClient.Agent().ServiceDeregister(systemInfo.Name)
-			ticker.Stop()
-			return
-		}
-	}
-}
-
 func (s *server) getSystemInfo(ctx context.Context) (*systemInfo, error) {
 	if s.config.username != "" {
 		ctx = metadata.AppendToOutgoingContext(ctx, "username", s.config.username)
@@ -683,15 +749,15 @@ START:
 	case <-sctx.Done():
 		return nil, ctx.Err()
 	default:
-		conn, gnmiClient, err := createGNMIClient(sctx)
+		gnmiClient, err := s.gnmi.gnmiClient(sctx)
 		if err != nil {
-			log.Errorf("failed to create a gnmi connection to %q: %v", gnmiServerUnixSocket, err)
+			log.Errorf("failed to get a gnmi client: %v", err)
 			time.Sleep(retryInterval)
 			goto START
 		}
-		defer conn.Close()
-
-		rsp, err := gnmiClient.Get(sctx,
+		gctx, gcancel := context.WithTimeout(sctx, retryInterval)
+		defer gcancel()
+		rsp, err := gnmiClient.Get(gctx,
 			&gnmi.GetRequest{
 				Path:     sysInfoPaths,
 				Type:     gnmi.GetRequest_STATE,
@@ -754,6 +820,13 @@ func getPathKeyVal(p *gnmi.Path, elem, key string) string {
 	return ""
 }
 
+func (s *server) handleGNMIStateChange(ctx context.Context, st connectivity.State) {
+	s.config.m.Lock()
+	defer s.config.m.Unlock()
+	s.config.baseConfig.GNMIConnectionState = connectivityState(st)
+	s.updatePrometheusBaseTelemetry(ctx, s.config.baseConfig)
+}
+
 type healthHandler struct{}
 
 func (h *healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -763,17 +836,3 @@ func (h *healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	http.NotFound(w, r)
 }
-
-func createGNMIClient(ctx context.Context) (*grpc.ClientConn, gnmi.GNMIClient, error) {
-	timeoutCtx, cancel := context.WithTimeout(ctx, retryInterval)
-	defer cancel()
-	conn, err := grpc.DialContext(timeoutCtx,
-		gnmiServerUnixSocket,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithBlock(),
-	)
-	if err != nil {
-		return nil, nil, err
-	}
-	return conn, gnmi.NewGNMIClient(conn), nil
-}
@@ -0,0 +1,364 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/gnmic/pkg/formatters"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	defaultSampleInterval = 10 * time.Second
+
+	streamModeSample   = "MODE_sample"
+	streamModeOnChange = "MODE_on_change"
+)
+
+type streaming struct {
+	AdminState     string      `json:"admin_state,omitempty"`
+	Mode           string      `json:"mode,omitempty"`
+	SampleInterval stringValue `json:"sample_interval,omitempty"`
+}
+
+func (s *server) streamingEnabled() bool {
+	return s.config.baseConfig.Streaming != nil && s.config.baseConfig.Streaming.AdminState == adminEnable
+}
+
+// eventCache stores the latest events received on the streaming subscriptions,
+// per metric name and per set of tags.
+type eventCache struct {
+	m      *sync.RWMutex
+	events map[string]map[string]*formatters.EventMsg
+	// metrics with a subscription that received updates since the last purge
+	synced map[string]bool
+	// subscription generation per metric, incremented on each purge,
+	// the updates of a previous generation are dropped
+	gens map[string]uint64
+}
+
+func newEventCache() *eventCache {
+	return &eventCache{
+		m:      new(sync.RWMutex),
+		events: make(map[string]map[string]*formatters.EventMsg),
+		synced: make(map[string]bool),
+		gens:   make(map[string]uint64),
+	}
+}
+
+// update applies a subscribe response of the subscription generation gen of metric name,
+// it is ignored if the subscription was restarted or stopped since.
+func (c *eventCache) update(name string, gen uint64, rsp *gnmi.SubscribeResponse) error {
+	notif := rsp.GetUpdate()
+	if notif == nil {
+		if rsp.GetSyncResponse() {
+			c.m.Lock()
+			if c.gens[name] == gen {
+				c.synced[name] = true
+			}
+			c.m.Unlock()
+		}
+		return nil
+	}
+	events, err := formatters.ResponseToEventMsgs("", rsp, nil)
+	if err != nil {
+		return err
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.gens[name] != gen {
+		return nil
+	}
+	c.synced[name] = true
+	if _, ok := c.events[name]; !ok {
+		c.events[name] = make(map[string]*formatters.EventMsg)
+	}
+	for _, ev := range events {
+		if len(ev.Values) == 0 {
+			continue
+		}
+		key := tagsKey(ev.Tags)
+		cached, ok := c.events[name][key]
+		if !ok {
+			c.events[name][key] = ev
+			continue
+		}
+		cached.Timestamp = ev.Timestamp
+		for k, v := range ev.Values {
+			cached.Values[k] = v
+		}
+	}
+	for _, del := range notif.GetDelete() {
+		pathName, tags := pathTags(notif.GetPrefix(), del)
+		for key, cached := range c.events[name] {
+			if !tagsMatch(cached.Tags, tags) {
+				continue
+			}
+			for vn := range cached.Values {
+				if vn == pathName || strings.HasPrefix(vn, pathName+"/") {
+					delete(cached.Values, vn)
+				}
+			}
+			if len(cached.Values) == 0 {
+				delete(c.events[name], key)
+			}
+		}
+	}
+	return nil
+}
+
+// get returns a copy of the cached events of metric name.
+func (c *eventCache) get(name string) []*formatters.EventMsg {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	events := make([]*formatters.EventMsg, 0, len(c.events[name]))
+	for _, ev := range c.events[name] {
+		nev := &formatters.EventMsg{
+			Name:      ev.Name,
+			Timestamp: ev.Timestamp,
+			Tags:      make(map[string]string, len(ev.Tags)),
+			Values:    make(map[string]interface{}, len(ev.Values)),
+		}
+		for k, v := range ev.Tags {
+			nev.Tags[k] = v
+		}
+		for k, v := range ev.Values {
+			nev.Values[k] = v
+		}
+		events = append(events, nev)
+	}
+	return events
+}
+
+// purge removes the cached events of metric name and starts a new subscription generation,
+// which is returned.
+func (c *eventCache) purge(name string) uint64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+	delete(c.events, name)
+	delete(c.synced, name)
+	c.gens[name]++
+	return c.gens[name]
+}
+
+// clear removes the cached events of metric name
+// if gen is still the current subscription generation.
+func (c *eventCache) clear(name string, gen uint64) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.gens[name] != gen {
+		return
+	}
+	delete(c.events, name)
+	delete(c.synced, name)
+}
+
+// healthy returns true if the subscription of metric name
+// received updates since it was (re)started.
+func (c *eventCache) healthy(name string) bool {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.synced[name]
+}
+
+func tagsKey(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	sb := strings.Builder{}
+	for _, k := range keys {
+		sb.WriteString(k)
+		sb.WriteString("=")
+		sb.WriteString(tags[k])
+		sb.WriteString(",")
+	}
+	return sb.String()
+}
+
+// tagsMatch returns true if all the tags in subset are found in tags.
+func tagsMatch(tags, subset map[string]string) bool {
+	for k, v := range subset {
+		if tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// pathTags returns the path name without keys and the keys of the prefix and path,
+// named the same way as the event message tags.
+func pathTags(prefix, p *gnmi.Path) (string, map[string]string) {
+	tags := make(map[string]string)
+	sb := strings.Builder{}
+	elems := append(append([]*gnmi.PathElem{}, prefix.GetElem()...), p.GetElem()...)
+	for _, e := range elems {
+		sb.WriteString("/")
+		sb.WriteString(e.GetName())
+		names := strings.Split(e.GetName(), ":")
+		for k, v := range e.GetKey() {
+			tags[names[len(names)-1]+"_"+k] = v
+		}
+	}
+	return sb.String(), tags
+}
+
+// startStreaming starts a streaming subscription for each enabled metric.
+func (s *server) startStreaming(ctx context.Context) {
+	s.config.m.Lock()
+	defer s.config.m.Unlock()
+	for name, m := range s.config.metrics {
+		if m.Metric.State == stateEnable {
+			s.startSubscription(ctx, name)
+		}
+	}
+	for name, m := range s.config.customMetric {
+		if m.Metric.State == stateEnable {
+			s.startSubscription(ctx, name)
+		}
+	}
+}
+
+// stopStreaming stops all the streaming subscriptions and clears the cache.
+func (s *server) stopStreaming() {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	for name, cancel := range s.streamCancelFns {
+		cancel()
+		delete(s.streamCancelFns, name)
+		s.cache.purge(name)
+	}
+}
+
+// syncSubscription starts, restarts or stops the streaming subscription of metric name
+// based on its current state.
+// assumes config is already locked
+func (s *server) syncSubscription(ctx context.Context, name string) {
+	if !s.streamingEnabled() || s.config.baseConfig.OperState != operUp {
+		return
+	}
+	var state string
+	if m, ok := s.config.metrics[name]; ok {
+		state = m.Metric.State
+	} else if m, ok := s.config.customMetric[name]; ok {
+		state = m.Metric.State
+	}
+	s.stopSubscription(name)
+	if state == stateEnable {
+		s.startSubscription(ctx, name)
+	}
+}
+
+// assumes config is already locked
+func (s *server) startSubscription(ctx context.Context, name string) {
+	req, err := s.createSubscribeRequest(name)
+	if err != nil {
+		log.Errorf("failed to create subscribe request for metric %q: %v", name, err)
+		return
+	}
+	sampleInterval := defaultSampleInterval
+	if s.config.baseConfig.Streaming.SampleInterval.Value != "" {
+		sampleInterval, err = time.ParseDuration(s.config.baseConfig.Streaming.SampleInterval.Value)
+		if err != nil {
+			log.Errorf("invalid sample-interval %q: %v", s.config.baseConfig.Streaming.SampleInterval.Value, err)
+			sampleInterval = defaultSampleInterval
+		}
+	}
+	subList := req.GetSubscribe()
+	subList.Mode = gnmi.SubscriptionList_STREAM
+	for _, sub := range subList.GetSubscription() {
+		switch s.config.baseConfig.Streaming.Mode {
+		case streamModeOnChange:
+			sub.Mode = gnmi.SubscriptionMode_ON_CHANGE
+		default:
+			sub.Mode = gnmi.SubscriptionMode_SAMPLE
+			sub.SampleInterval = uint64(sampleInterval.Nanoseconds())
+		}
+	}
+
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	if cancel, ok := s.streamCancelFns[name]; ok {
+		cancel()
+	}
+	// the updates still received by the previous subscription are dropped
+	gen := s.cache.purge(name)
+	sctx, cancel := context.WithCancel(ctx)
+	s.streamCancelFns[name] = cancel
+	go s.runSubscription(sctx, name, gen, req)
+}
+
+func (s *server) stopSubscription(name string) {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	if cancel, ok := s.streamCancelFns[name]; ok {
+		cancel()
+		delete(s.streamCancelFns, name)
+	}
+	s.cache.purge(name)
+}
+
+func (s *server) runSubscription(ctx context.Context, name string, gen uint64, req *gnmi.SubscribeRequest) {
+	if s.config.username != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "username", s.config.username)
+	}
+	if s.config.password != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "password", s.config.password)
+	}
+	for {
+		log.Infof("starting streaming subscription for metric %q", name)
+		err := s.subscribe(ctx, name, gen, req)
+		if ctx.Err() != nil {
+			log.Infof("streaming subscription for metric %q stopped", name)
+			return
+		}
+		log.Errorf("streaming subscription for metric %q failed: %v", name, err)
+		s.metrics.gnmiError(name, err)
+		// do not expose stale data
+		s.cache.clear(name, gen)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (s *server) subscribe(ctx context.Context, name string, gen uint64, req *gnmi.SubscribeRequest) error {
+	gnmiClient, err := s.gnmi.gnmiClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	subClient, err := gnmiClient.Subscribe(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create a subscribe client: %v", err)
+	}
+	err = subClient.Send(req)
+	if err != nil {
+		return fmt.Errorf("failed to send a subscribe request: %v", err)
+	}
+	for {
+		subResp, err := subClient.Recv()
+		if err == io.EOF {
+			return fmt.Errorf("subscription closed by the server")
+		}
+		if err != nil {
+			return err
+		}
+		err = s.cache.update(name, gen, subResp)
+		if err != nil {
+			log.Errorf("metric %q: failed to process subscribe response: %v", name, err)
+			continue
+		}
+		s.metrics.collected(name)
+	}
+}
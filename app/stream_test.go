@@ -0,0 +1,86 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+func interfaceUpdate(ifName, leaf string, v uint64) *gnmi.SubscribeResponse {
+	return &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: 1,
+				Prefix: &gnmi.Path{
+					Elem: []*gnmi.PathElem{{Name: "interface", Key: map[string]string{"name": ifName}}},
+				},
+				Update: []*gnmi.Update{
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "statistics"}, {Name: leaf}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: v}},
+					},
+				},
+			},
+		},
+	}
+}
+
+var syncResponse = &gnmi.SubscribeResponse{
+	Response: &gnmi.SubscribeResponse_SyncResponse{SyncResponse: true},
+}
+
+func TestEventCacheRestart(t *testing.T) {
+	c := newEventCache()
+	name := "interfaces"
+
+	// first subscription
+	oldGen := c.purge(name)
+	if err := c.update(name, oldGen, interfaceUpdate("ethernet-1/1", "in-octets", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.update(name, oldGen, syncResponse); err != nil {
+		t.Fatal(err)
+	}
+	if evs := c.get(name); len(evs) != 1 || !c.healthy(name) {
+		t.Fatalf("expected 1 cached event and a healthy subscription, got %d events, healthy=%v", len(evs), c.healthy(name))
+	}
+
+	// restarted subscription, the old one is still receiving updates
+	newGen := c.purge(name)
+	if err := c.update(name, oldGen, interfaceUpdate("ethernet-1/2", "in-octets", 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.update(name, oldGen, syncResponse); err != nil {
+		t.Fatal(err)
+	}
+	if evs := c.get(name); len(evs) != 0 {
+		t.Fatalf("expected the old subscription events to be dropped, got %v", evs)
+	}
+	if c.healthy(name) {
+		t.Fatal("expected the restarted subscription not to be healthy before its first update")
+	}
+	// a failure of the old subscription does not clear the new one
+	if err := c.update(name, newGen, interfaceUpdate("ethernet-1/3", "out-octets", 3)); err != nil {
+		t.Fatal(err)
+	}
+	c.clear(name, oldGen)
+	evs := c.get(name)
+	if len(evs) != 1 {
+		t.Fatalf("expected 1 cached event, got %d", len(evs))
+	}
+	if evs[0].Tags["interface_name"] != "ethernet-1/3" {
+		t.Errorf("unexpected cached event %v", evs[0])
+	}
+	if !c.healthy(name) {
+		t.Error("expected the restarted subscription to be healthy")
+	}
+
+	// stopped subscription
+	c.purge(name)
+	if err := c.update(name, newGen, interfaceUpdate("ethernet-1/3", "out-octets", 4)); err != nil {
+		t.Fatal(err)
+	}
+	if evs := c.get(name); len(evs) != 0 {
+		t.Fatalf("expected no events after stop, got %v", evs)
+	}
+}
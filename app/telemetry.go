@@ -96,3 +96,161 @@ func (s *server) deleteCustomMetricTelemetry(ctx context.Context, name string) {
 	log.Debugf("Deleting telemetry path %s", jsPath)
 	s.deleteTelemetry(ctx, jsPath)
 }
+
+// custom metrics leaf types
+func (s *server) updateLeafTypeTelemetry(ctx context.Context, name, leaf string, cfg *leafTypeConfig) {
+	jsData, err := json.Marshal(cfg)
+	if err != nil {
+		log.Errorf("failed to marshal json data: %v", err)
+		return
+	}
+	s.updateTelemetry(ctx, fmt.Sprintf("%s{.name==\"%s\"}.leaf_type{.name==\"%s\"}", customMetricPath, name, leaf), string(jsData))
+}
+
+func (s *server) deleteLeafTypeTelemetry(ctx context.Context, name, leaf string) {
+	jsPath := fmt.Sprintf("%s{.name==\"%s\"}.leaf_type{.name==\"%s\"}", customMetricPath, name, leaf)
+	log.Debugf("Deleting telemetry path %s", jsPath)
+	s.deleteTelemetry(ctx, jsPath)
+}
+
+// custom metrics relabel rules
+func (s *server) updateRelabelRuleTelemetry(ctx context.Context, name string, index int, cfg *relabelRuleConfig) {
+	jsData, err := json.Marshal(cfg)
+	if err != nil {
+		log.Errorf("failed to marshal json data: %v", err)
+		return
+	}
+	s.updateTelemetry(ctx, fmt.Sprintf("%s{.name==\"%s\"}.relabel_rule{.index==%d}", customMetricPath, name, index), string(jsData))
+}
+
+func (s *server) deleteRelabelRuleTelemetry(ctx context.Context, name string, index int) {
+	jsPath := fmt.Sprintf("%s{.name==\"%s\"}.relabel_rule{.index==%d}", customMetricPath, name, index)
+	log.Debugf("Deleting telemetry path %s", jsPath)
+	s.deleteTelemetry(ctx, jsPath)
+}
+
+// custom metrics value maps
+func (s *server) updateValueMapTelemetry(ctx context.Context, name, value string, cfg *valueMapConfig) {
+	jsData, err := json.Marshal(cfg)
+	if err != nil {
+		log.Errorf("failed to marshal json data: %v", err)
+		return
+	}
+	s.updateTelemetry(ctx, fmt.Sprintf("%s{.name==\"%s\"}.value_map{.value==\"%s\"}", customMetricPath, name, value), string(jsData))
+}
+
+func (s *server) deleteValueMapTelemetry(ctx context.Context, name, value string) {
+	jsPath := fmt.Sprintf("%s{.name==\"%s\"}.value_map{.value==\"%s\"}", customMetricPath, name, value)
+	log.Debugf("Deleting telemetry path %s", jsPath)
+	s.deleteTelemetry(ctx, jsPath)
+}
+
+// static labels
+func staticLabelTelemetryPath(jsPath string, keys []string) string {
+	switch jsPath {
+	case metricStaticLabelPath:
+		return fmt.Sprintf("%s{.name==\"%s\"}.static_label{.name==\"%s\"}", metricPath, keys[0], keys[1])
+	case customMetricStaticLabelPath:
+		return fmt.Sprintf("%s{.name==\"%s\"}.static_label{.name==\"%s\"}", customMetricPath, keys[0], keys[1])
+	}
+	return fmt.Sprintf("%s{.name==\"%s\"}", staticLabelPath, keys[0])
+}
+
+func (s *server) updateStaticLabelTelemetry(ctx context.Context, jsPath string, keys []string, cfg *staticLabelConfig) {
+	jsData, err := json.Marshal(cfg)
+	if err != nil {
+		log.Errorf("failed to marshal json data: %v", err)
+		return
+	}
+	s.updateTelemetry(ctx, staticLabelTelemetryPath(jsPath, keys), string(jsData))
+}
+
+func (s *server) deleteStaticLabelTelemetry(ctx context.Context, jsPath string, keys []string) {
+	telemetryPath := staticLabelTelemetryPath(jsPath, keys)
+	log.Debugf("Deleting telemetry path %s", telemetryPath)
+	s.deleteTelemetry(ctx, telemetryPath)
+}
+
+// authentication
+func (s *server) updateAuthUserTelemetry(ctx context.Context, username string, cfg *authUserConfig) {
+	jsData, err := json.Marshal(cfg)
+	if err != nil {
+		log.Errorf("failed to marshal json data: %v", err)
+		return
+	}
+	jsPath := fmt.Sprintf("%s{.username==\"%s\"}", authUserPath, username)
+	s.updateTelemetry(ctx, jsPath, string(jsData))
+}
+
+func (s *server) deleteAuthUserTelemetry(ctx context.Context, username string) {
+	jsPath := fmt.Sprintf("%s{.username==\"%s\"}", authUserPath, username)
+	log.Debugf("Deleting telemetry path %s", jsPath)
+	s.deleteTelemetry(ctx, jsPath)
+}
+
+func (s *server) updateAuthTokenTelemetry(ctx context.Context, name string, cfg *authTokenConfig) {
+	jsData, err := json.Marshal(cfg)
+	if err != nil {
+		log.Errorf("failed to marshal json data: %v", err)
+		return
+	}
+	jsPath := fmt.Sprintf("%s{.name==\"%s\"}", authTokenPath, name)
+	s.updateTelemetry(ctx, jsPath, string(jsData))
+}
+
+func (s *server) deleteAuthTokenTelemetry(ctx context.Context, name string) {
+	jsPath := fmt.Sprintf("%s{.name==\"%s\"}", authTokenPath, name)
+	log.Debugf("Deleting telemetry path %s", jsPath)
+	s.deleteTelemetry(ctx, jsPath)
+}
+
+// remote write
+func (s *server) updateRemoteWriteEndpointTelemetry(ctx context.Context, name string, cfg *remoteWriteEndpointConfig) {
+	jsData, err := json.Marshal(cfg)
+	if err != nil {
+		log.Errorf("failed to marshal json data: %v", err)
+		return
+	}
+	jsPath := fmt.Sprintf("%s{.name==\"%s\"}", remoteWriteEndpointPath, name)
+	s.updateTelemetry(ctx, jsPath, string(jsData))
+}
+
+func (s *server) deleteRemoteWriteEndpointTelemetry(ctx context.Context, name string) {
+	jsPath := fmt.Sprintf("%s{.name==\"%s\"}", remoteWriteEndpointPath, name)
+	log.Debugf("Deleting telemetry path %s", jsPath)
+	s.deleteTelemetry(ctx, jsPath)
+}
+
+// otlp
+func (s *server) updateOTLPHeaderTelemetry(ctx context.Context, name string, cfg *otlpHeaderConfig) {
+	jsData, err := json.Marshal(cfg)
+	if err != nil {
+		log.Errorf("failed to marshal json data: %v", err)
+		return
+	}
+	jsPath := fmt.Sprintf("%s{.name==\"%s\"}", otlpHeaderPath, name)
+	s.updateTelemetry(ctx, jsPath, string(jsData))
+}
+
+func (s *server) deleteOTLPHeaderTelemetry(ctx context.Context, name string) {
+	jsPath := fmt.Sprintf("%s{.name==\"%s\"}", otlpHeaderPath, name)
+	log.Debugf("Deleting telemetry path %s", jsPath)
+	s.deleteTelemetry(ctx, jsPath)
+}
+
+// scrape profiles
+func (s *server) updateScrapeProfileTelemetry(ctx context.Context, name string, cfg *scrapeProfileConfig) {
+	jsData, err := json.Marshal(cfg)
+	if err != nil {
+		log.Errorf("failed to marshal json data: %v", err)
+		return
+	}
+	jsPath := fmt.Sprintf("%s{.name==\"%s\"}", scrapeProfilePath, name)
+	s.updateTelemetry(ctx, jsPath, string(jsData))
+}
+
+func (s *server) deleteScrapeProfileTelemetry(ctx context.Context, name string) {
+	jsPath := fmt.Sprintf("%s{.name==\"%s\"}", scrapeProfilePath, name)
+	log.Debugf("Deleting telemetry path %s", jsPath)
+	s.deleteTelemetry(ctx, jsPath)
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxTimestampSkew = 30 * time.Second
+	// series not seen for this duration are removed from the timestamps tracker
+	timestampsTrackerTTL = 10 * time.Minute
+)
+
+type deviceTimestamps struct {
+	AdminState string      `json:"admin_state,omitempty"`
+	MaxSkew    stringValue `json:"max_skew,omitempty"`
+}
+
+// deviceTimestampsEnabled returns true if the samples carry the gNMI notifications timestamps,
+// and the max allowed difference between a notification timestamp and the scrape time.
+// assumes config is already locked
+func (s *server) deviceTimestampsEnabled() (bool, time.Duration) {
+	dt := s.config.baseConfig.DeviceTimestamps
+	if dt == nil || dt.AdminState != adminEnable {
+		return false, 0
+	}
+	if dt.MaxSkew.Value == "" {
+		return true, defaultMaxTimestampSkew
+	}
+	d, err := time.ParseDuration(dt.MaxSkew.Value)
+	if err != nil || d <= 0 {
+		return true, defaultMaxTimestampSkew
+	}
+	return true, d
+}
+
+type seriesTimestamp struct {
+	last     time.Time
+	lastSeen time.Time
+}
+
+// timestampsTracker keeps the last timestamp emitted per series,
+// so that a series never goes back in time.
+type timestampsTracker struct {
+	m         *sync.Mutex
+	series    map[string]*seriesTimestamp
+	lastSweep time.Time
+}
+
+func newTimestampsTracker() *timestampsTracker {
+	return &timestampsTracker{
+		m:      new(sync.Mutex),
+		series: make(map[string]*seriesTimestamp),
+	}
+}
+
+// sampleTime returns the timestamp of a sample of series key, received with the notification timestamp ts,
+// in nanoseconds. The scrape time is used if the difference between ts and the scrape time exceeds maxSkew.
+// The returned string is the reason the notification timestamp was not used, if any.
+// The returned bool is false if the sample must be dropped because it is older than the last emitted one.
+func (t *timestampsTracker) sampleTime(key string, ts int64, now time.Time, maxSkew time.Duration) (time.Time, string, bool) {
+	var reason string
+	sampleTime := time.Unix(0, ts)
+	if ts <= 0 || sampleTime.After(now.Add(maxSkew)) || sampleTime.Before(now.Add(-maxSkew)) {
+		sampleTime = now
+		reason = "skew"
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	if now.Sub(t.lastSweep) > timestampsTrackerTTL {
+		for k, st := range t.series {
+			if now.Sub(st.lastSeen) > timestampsTrackerTTL {
+				delete(t.series, k)
+			}
+		}
+		t.lastSweep = now
+	}
+	st, ok := t.series[key]
+	if !ok {
+		t.series[key] = &seriesTimestamp{last: sampleTime, lastSeen: now}
+		return sampleTime, reason, true
+	}
+	st.lastSeen = now
+	if reason == "" && sampleTime.Equal(st.last) {
+		// same notification, scraped again
+		return sampleTime, "", true
+	}
+	if !sampleTime.After(st.last) {
+		// out of order notification timestamp,
+		// fallback to the scrape time if it is ahead of the last emitted timestamp
+		if !now.After(st.last) {
+			return sampleTime, "out_of_order", false
+		}
+		sampleTime = now
+		reason = "out_of_order"
+	}
+	st.last = sampleTime
+	return sampleTime, reason, true
+}
+
+func seriesKey(name string, values []string) string {
+	return name + "\xff" + strings.Join(values, "\xff")
+}
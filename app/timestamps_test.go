@@ -0,0 +1,113 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimestampsTrackerSampleTime(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	at := func(d time.Duration) time.Time { return base.Add(d) }
+	maxSkew := 30 * time.Second
+
+	type sample struct {
+		// notification timestamp, zero for a missing timestamp
+		ts  time.Time
+		now time.Time
+		// expected sample time, fallback reason and keep
+		want       time.Time
+		wantReason string
+		wantOK     bool
+	}
+	tests := []struct {
+		name    string
+		samples []sample
+	}{
+		{
+			name: "notification timestamp within the skew",
+			samples: []sample{
+				{ts: at(-10 * time.Second), now: at(0), want: at(-10 * time.Second), wantOK: true},
+			},
+		},
+		{
+			name: "notification timestamp too old",
+			samples: []sample{
+				{ts: at(-time.Minute), now: at(0), want: at(0), wantReason: "skew", wantOK: true},
+			},
+		},
+		{
+			name: "notification timestamp in the future",
+			samples: []sample{
+				{ts: at(time.Minute), now: at(0), want: at(0), wantReason: "skew", wantOK: true},
+			},
+		},
+		{
+			name: "missing notification timestamp",
+			samples: []sample{
+				{now: at(0), want: at(0), wantReason: "skew", wantOK: true},
+			},
+		},
+		{
+			name: "same notification scraped again",
+			samples: []sample{
+				{ts: at(-5 * time.Second), now: at(0), want: at(-5 * time.Second), wantOK: true},
+				{ts: at(-5 * time.Second), now: at(15 * time.Second), want: at(-5 * time.Second), wantOK: true},
+			},
+		},
+		{
+			name: "newer notification",
+			samples: []sample{
+				{ts: at(-5 * time.Second), now: at(0), want: at(-5 * time.Second), wantOK: true},
+				{ts: at(10 * time.Second), now: at(15 * time.Second), want: at(10 * time.Second), wantOK: true},
+			},
+		},
+		{
+			name: "out of order notification falls back to the scrape time",
+			samples: []sample{
+				{ts: at(-5 * time.Second), now: at(0), want: at(-5 * time.Second), wantOK: true},
+				{ts: at(-10 * time.Second), now: at(15 * time.Second), want: at(15 * time.Second), wantReason: "out_of_order", wantOK: true},
+			},
+		},
+		{
+			name: "out of order notification behind the last scrape time is dropped",
+			samples: []sample{
+				// skewed, the scrape time is emitted
+				{ts: at(-time.Minute), now: at(0), want: at(0), wantReason: "skew", wantOK: true},
+				{ts: at(-5 * time.Second), now: at(0), want: at(-5 * time.Second), wantReason: "out_of_order", wantOK: false},
+			},
+		},
+		{
+			name: "skew fallback after a notification timestamp",
+			samples: []sample{
+				{ts: at(-5 * time.Second), now: at(0), want: at(-5 * time.Second), wantOK: true},
+				{ts: at(-time.Minute), now: at(15 * time.Second), want: at(15 * time.Second), wantReason: "skew", wantOK: true},
+			},
+		},
+		{
+			name: "skew fallback behind the last emitted timestamp",
+			samples: []sample{
+				{ts: at(20 * time.Second), now: at(0), want: at(20 * time.Second), wantOK: true},
+				{ts: at(-time.Minute), now: at(10 * time.Second), wantReason: "out_of_order", wantOK: false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newTimestampsTracker()
+			key := seriesKey("interfaces_in_octets", []string{"ethernet-1/1"})
+			for i, smpl := range tt.samples {
+				var ts int64
+				if !smpl.ts.IsZero() {
+					ts = smpl.ts.UnixNano()
+				}
+				got, reason, ok := tracker.sampleTime(key, ts, smpl.now, maxSkew)
+				if ok != smpl.wantOK || reason != smpl.wantReason {
+					t.Fatalf("sample %d: got reason=%q ok=%v, expected reason=%q ok=%v", i, reason, ok, smpl.wantReason, smpl.wantOK)
+				}
+				if ok && !got.Equal(smpl.want) {
+					t.Fatalf("sample %d: got time %v, expected %v", i, got, smpl.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	gpath "github.com/openconfig/gnmic/pkg/path"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	tlsProfileCheckInterval = 30 * time.Second
+)
+
+// tlsProfile holds the relevant fields of a
+// /system/tls/server-profile list entry.
+type tlsProfile struct {
+	Name               string
+	Key                string
+	Certificate        string
+	TrustAnchor        string
+	AuthenticateClient bool
+}
+
+func (p *tlsProfile) equal(o *tlsProfile) bool {
+	if p == nil || o == nil {
+		return p == o
+	}
+	return *p == *o
+}
+
+// tlsStore keeps the certificates loaded from the configured TLS profile.
+// The tls.Config returned by config() looks up the current
+// certificates on each handshake, so they can be swapped without
+// restarting the listener.
+type tlsStore struct {
+	m       *sync.RWMutex
+	profile *tlsProfile
+	cert    *tls.Certificate
+	caPool  *x509.CertPool
+}
+
+func newTLSStore() *tlsStore {
+	return &tlsStore{m: new(sync.RWMutex)}
+}
+
+func (t *tlsStore) load(p *tlsProfile) error {
+	if p.Certificate == "" || p.Key == "" {
+		return fmt.Errorf("tls-profile %q: missing certificate or key", p.Name)
+	}
+	cert, err := tls.X509KeyPair([]byte(p.Certificate), []byte(p.Key))
+	if err != nil {
+		return fmt.Errorf("tls-profile %q: failed to load key pair: %v", p.Name, err)
+	}
+	var caPool *x509.CertPool
+	if p.TrustAnchor != "" {
+		caPool = x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM([]byte(p.TrustAnchor)) {
+			return fmt.Errorf("tls-profile %q: failed to parse trust-anchor", p.Name)
+		}
+	}
+	if p.AuthenticateClient && caPool == nil {
+		return fmt.Errorf("tls-profile %q: authenticate-client is set without a trust-anchor", p.Name)
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.profile = p
+	t.cert = &cert
+	t.caPool = caPool
+	return nil
+}
+
+func (t *tlsStore) current() *tlsProfile {
+	t.m.RLock()
+	defer t.m.RUnlock()
+	return t.profile
+}
+
+func (t *tlsStore) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			t.m.RLock()
+			defer t.m.RUnlock()
+			if t.cert == nil {
+				return nil, errors.New("no certificate loaded")
+			}
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*t.cert},
+				ClientCAs:    t.caPool,
+				ClientAuth:   tls.NoClientCert,
+			}
+			if t.profile.AuthenticateClient {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// watchTLSProfile periodically reads the configured TLS profile
+// and reloads the certificates of store when it changes.
+func (s *server) watchTLSProfile(ctx context.Context, store *tlsStore) {
+	ticker := time.NewTicker(tlsProfileCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.config.m.Lock()
+			name := s.config.baseConfig.TLSProfile.Value
+			s.config.m.Unlock()
+			s.reloadTLSProfile(ctx, store, name)
+		}
+	}
+}
+
+// clientAuthRequired returns true if the loaded TLS profile requires a client certificate.
+func (s *server) clientAuthRequired() bool {
+	s.config.m.Lock()
+	ts := s.tlsStore
+	s.config.m.Unlock()
+	if ts == nil {
+		return false
+	}
+	p := ts.current()
+	return p != nil && p.AuthenticateClient
+}
+
+// reloadTLSProfile reads TLS profile name and loads it in store if it changed,
+// store is captured by the caller under the config lock since a server restart replaces it.
+func (s *server) reloadTLSProfile(ctx context.Context, store *tlsStore, name string) {
+	if name == "" || store == nil {
+		return
+	}
+	p, err := s.getTLSProfile(ctx, name)
+	if err != nil {
+		log.Errorf("failed to get tls-profile %q: %v", name, err)
+		return
+	}
+	if p.equal(store.current()) {
+		return
+	}
+	err = store.load(p)
+	if err != nil {
+		log.Errorf("failed to reload tls-profile %q: %v", name, err)
+		return
+	}
+	log.Infof("reloaded tls-profile %q", name)
+}
+
+func (s *server) getTLSProfile(ctx context.Context, name string) (*tlsProfile, error) {
+	if s.config.username != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "username", s.config.username)
+	}
+	if s.config.password != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "password", s.config.password)
+	}
+	gnmiClient, err := s.gnmi.gnmiClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, retryInterval)
+	defer cancel()
+
+	leaves := []string{"key", "certificate", "trust-anchor", "authenticate-client"}
+	paths := make([]*gnmi.Path, 0, len(leaves))
+	for _, l := range leaves {
+		paths = append(paths, &gnmi.Path{
+			Elem: []*gnmi.PathElem{
+				{Name: "system"},
+				{Name: "tls"},
+				{Name: "server-profile", Key: map[string]string{"name": name}},
+				{Name: l},
+			},
+		})
+	}
+	rsp, err := gnmiClient.Get(ctx,
+		&gnmi.GetRequest{
+			Path:     paths,
+			Type:     gnmi.GetRequest_CONFIG,
+			Encoding: gnmi.Encoding_ASCII,
+		})
+	if err != nil {
+		return nil, err
+	}
+	p := &tlsProfile{Name: name}
+	for _, n := range rsp.GetNotification() {
+		for _, u := range n.GetUpdate() {
+			xp := gpath.GnmiPathToXPath(u.GetPath(), true)
+			val := u.GetVal().GetStringVal()
+			switch {
+			case strings.HasSuffix(xp, "/key"):
+				p.Key = val
+			case strings.HasSuffix(xp, "/certificate"):
+				p.Certificate = val
+			case strings.HasSuffix(xp, "/trust-anchor"):
+				p.TrustAnchor = val
+			case strings.HasSuffix(xp, "/authenticate-client"):
+				p.AuthenticateClient = val == "true" || u.GetVal().GetBoolVal()
+			}
+		}
+	}
+	return p, nil
+}
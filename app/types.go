@@ -0,0 +1,126 @@
+package app
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	typeCounter = "counter"
+	typeGauge   = "gauge"
+	typeUntyped = "untyped"
+)
+
+// typeRule maps a leaf name pattern to a prometheus value type.
+type typeRule struct {
+	re  *regexp.Regexp
+	typ prometheus.ValueType
+}
+
+// defaultTypeRules are evaluated in order against the leaf name (basename of the value path),
+// the first match wins.
+var defaultTypeRules = []typeRule{
+	{re: regexp.MustCompile(`(^|-)(utilization|usage)$`), typ: prometheus.GaugeValue},
+	{re: regexp.MustCompile(`(^|-)(octets|packets|pkts|errors|discards|drops|dropped|transitions)$`), typ: prometheus.CounterValue},
+	{re: regexp.MustCompile(`(^|-)(memory|free|used|reserved|physical|total|size|temperature|level)$`), typ: prometheus.GaugeValue},
+	{re: regexp.MustCompile(`(^|-)(percent|percentage)$`), typ: prometheus.GaugeValue},
+	{re: regexp.MustCompile(`(^|-)(state|status|last-change|last-clear)$`), typ: prometheus.GaugeValue},
+}
+
+// metricOptions holds per metric options read from the configuration file.
+type metricOptions struct {
+	// value type applied to all the metric leaves.
+	Type string `yaml:"type,omitempty"`
+	// value type per leaf name or path.
+	LeafTypes map[string]string `yaml:"leaf-types,omitempty"`
+	// relabel rules applied in order to each event labels.
+	Relabel []*relabelRule `yaml:"relabel,omitempty"`
+	// metric name per leaf name or path, overrides the metric naming strategy.
+	LeafNames map[string]string `yaml:"leaf-names,omitempty"`
+	// string value to number, applied to all the metric leaves.
+	ValueMap map[string]float64 `yaml:"value-map,omitempty"`
+	// string value to number per leaf name or path.
+	LeafValueMaps map[string]map[string]float64 `yaml:"leaf-value-maps,omitempty"`
+	// metric mode, set to "info" to expose the string leaves as labels of an info series.
+	Mode string `yaml:"mode,omitempty"`
+	// leaves exposed as labels in info mode, all the string leaves if empty.
+	InfoLeaves []string `yaml:"info-leaves,omitempty"`
+	// time allowed to collect the metric, overrides the exporter scrape-timeout.
+	ScrapeTimeout string `yaml:"scrape-timeout,omitempty"`
+	// series selectors, only the series matching one of the include selectors,
+	// and none of the exclude selectors are exported.
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+}
+
+func parseValueType(t string) (prometheus.ValueType, bool) {
+	// YANG enums are received as TYPE_<value>
+	switch strings.ToLower(strings.TrimPrefix(t, "TYPE_")) {
+	case typeCounter:
+		return prometheus.CounterValue, true
+	case typeGauge:
+		return prometheus.GaugeValue, true
+	case typeUntyped:
+		return prometheus.UntypedValue, true
+	}
+	return prometheus.UntypedValue, false
+}
+
+// lookupLeafType returns the value type set for valueName in types,
+// matching either the full value path or its basename.
+func lookupLeafType(types map[string]string, valueName string) (prometheus.ValueType, bool) {
+	t, ok := lookupLeaf(types, valueName)
+	if !ok {
+		return prometheus.UntypedValue, false
+	}
+	return parseValueType(t)
+}
+
+// lookupLeaf returns the entry of m matching valueName,
+// matching either the full value path, with or without a leading "/", or its basename.
+func lookupLeaf(m map[string]string, valueName string) (string, bool) {
+	if len(m) == 0 {
+		return "", false
+	}
+	if v, ok := m[valueName]; ok {
+		return v, true
+	}
+	if v, ok := m[strings.TrimPrefix(valueName, "/")]; ok {
+		return v, true
+	}
+	v, ok := m[path.Base(valueName)]
+	return v, ok
+}
+
+// metricType returns the prometheus value type of leaf valueName of metric name.
+// Per leaf overrides take precedence over per metric overrides,
+// which take precedence over the default rules.
+// assumes config is already locked
+func (s *server) metricType(name, valueName string) prometheus.ValueType {
+	if cm, ok := s.config.customMetric[name]; ok {
+		if t, ok := lookupLeafType(cm.leafTypes, valueName); ok {
+			return t
+		}
+		if t, ok := parseValueType(cm.Metric.Type); ok {
+			return t
+		}
+	}
+	if mo, ok := s.config.metricOptions[name]; ok && mo != nil {
+		if t, ok := lookupLeafType(mo.LeafTypes, valueName); ok {
+			return t
+		}
+		if t, ok := parseValueType(mo.Type); ok {
+			return t
+		}
+	}
+	leaf := path.Base(valueName)
+	for _, r := range defaultTypeRules {
+		if r.re.MatchString(leaf) {
+			return r.typ
+		}
+	}
+	return prometheus.UntypedValue
+}
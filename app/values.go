@@ -0,0 +1,155 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// valueMap maps an enumeration value to a number for a set of leaves.
+type valueMap struct {
+	re     *regexp.Regexp
+	values map[string]float64
+}
+
+var (
+	operStateValues = map[string]float64{
+		"down":          0,
+		"up":            1,
+		"empty":         2,
+		"downloading":   3,
+		"booting":       4,
+		"starting":      5,
+		"failed":        6,
+		"synchronizing": 7,
+		"upgrading":     8,
+		"low-power":     9,
+		"degraded":      10,
+		"warm-reboot":   11,
+		"waiting":       12,
+	}
+	bgpSessionStateValues = map[string]float64{
+		"idle":        1,
+		"connect":     2,
+		"active":      3,
+		"opensent":    4,
+		"openconfirm": 5,
+		"established": 6,
+	}
+	// generic values, used for the state, status and boolean like leaves
+	genericStateValues = map[string]float64{
+		"down":     0,
+		"up":       1,
+		"disable":  0,
+		"enable":   1,
+		"disabled": 0,
+		"enabled":  1,
+		"false":    0,
+		"true":     1,
+		"no":       0,
+		"yes":      1,
+		"inactive": 0,
+		"active":   1,
+	}
+)
+
+// builtinValueMaps are evaluated in order against the leaf name (basename of the value path),
+// the first match that contains the value wins.
+var builtinValueMaps = []valueMap{
+	{re: regexp.MustCompile(`^session-state$`), values: bgpSessionStateValues},
+	{re: regexp.MustCompile(`(^|-)(oper-state|oper-status)$`), values: operStateValues},
+	{re: regexp.MustCompile(`(^|-)(state|status|enabled|active)$`), values: genericStateValues},
+}
+
+// convertValue converts the value v of leaf valueName of metric name to a float64.
+// Numeric values are returned as is, booleans are converted to 0 or 1,
+// strings are looked up in the user defined value maps then in the built-in ones,
+// date-time strings are converted to unix seconds.
+// assumes config is already locked
+func (s *server) convertValue(name, valueName string, v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		if f, ok := s.lookupValueMaps(name, valueName, v); ok {
+			return f, nil
+		}
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f, nil
+		}
+		if f, ok := lookupBuiltinValueMaps(valueName, v); ok {
+			return f, nil
+		}
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return float64(t.UnixNano()) / float64(time.Second), nil
+		}
+		return math.NaN(), fmt.Errorf("cannot convert value %q of leaf %q", v, valueName)
+	case nil:
+		return math.NaN(), errors.New("nil value")
+	}
+	return getFloat(v)
+}
+
+// lookupValueMaps looks up a string value in the user defined value maps of metric name.
+// assumes config is already locked
+func (s *server) lookupValueMaps(name, valueName, v string) (float64, bool) {
+	stripped := stripIdentityPrefix(v)
+	if mo, ok := s.config.metricOptions[name]; ok && mo != nil {
+		for leaf, vm := range mo.LeafValueMaps {
+			if leaf != valueName && leaf != strings.TrimPrefix(valueName, "/") && leaf != path.Base(valueName) {
+				continue
+			}
+			if f, ok := lookupValue(vm, v, stripped); ok {
+				return f, true
+			}
+		}
+		if f, ok := lookupValue(mo.ValueMap, v, stripped); ok {
+			return f, true
+		}
+	}
+	if cm, ok := s.config.customMetric[name]; ok {
+		if f, ok := lookupValue(cm.valueMap, v, stripped); ok {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+func lookupBuiltinValueMaps(valueName, v string) (float64, bool) {
+	leaf := path.Base(valueName)
+	v = strings.ToLower(stripIdentityPrefix(v))
+	for _, vm := range builtinValueMaps {
+		if !vm.re.MatchString(leaf) {
+			continue
+		}
+		if f, ok := vm.values[v]; ok {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+func lookupValue(vm map[string]float64, vs ...string) (float64, bool) {
+	for _, v := range vs {
+		if f, ok := vm[v]; ok {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+// stripIdentityPrefix removes the YANG module prefix from an identityref value.
+func stripIdentityPrefix(v string) string {
+	if idx := strings.Index(v, ":"); idx >= 0 && !strings.Contains(v[:idx], " ") {
+		return v[idx+1:]
+	}
+	return v
+}
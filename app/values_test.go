@@ -0,0 +1,75 @@
+package app
+
+import (
+	"math"
+	"testing"
+)
+
+func TestConvertValue(t *testing.T) {
+	cfg := NewConfig(&FileConfig{
+		MetricOptions: map[string]*metricOptions{
+			"interfaces": {
+				ValueMap: map[string]float64{"lacp-active": 1, "lacp-passive": 2},
+				LeafValueMaps: map[string]map[string]float64{
+					"interface/oper-down-reason": {"port-admin-disabled": 1, "lag-member-down": 2},
+					// overrides the built-in oper-state values
+					"transceiver/oper-state": {"up": 10, "down": 20},
+				},
+			},
+		},
+	}, "prometheus-exporter", false)
+	cfg.customMetric["bgp"] = &customMetricConfig{valueMap: map[string]float64{"ipv4-unicast": 4}}
+	s := NewServer(WithConfig(cfg))
+
+	tests := []struct {
+		name      string
+		metric    string
+		valueName string
+		in        interface{}
+		want      float64
+		wantErr   bool
+	}{
+		{name: "bool true", metric: "interfaces", valueName: "interface/admin-enabled", in: true, want: 1},
+		{name: "bool false", metric: "interfaces", valueName: "interface/admin-enabled", in: false, want: 0},
+		{name: "numeric string", metric: "interfaces", valueName: "interface/statistics/in-octets", in: "42", want: 42},
+		{name: "uint", metric: "interfaces", valueName: "interface/statistics/in-octets", in: uint64(7), want: 7},
+		{name: "oper-state up", metric: "interfaces", valueName: "interface/oper-state", in: "up", want: 1},
+		{name: "oper-state down", metric: "interfaces", valueName: "interface/oper-state", in: "down", want: 0},
+		{name: "oper-state is case insensitive", metric: "interfaces", valueName: "interface/oper-state", in: "UP", want: 1},
+		{name: "oper-state degraded", metric: "platform", valueName: "platform/control/oper-state", in: "degraded", want: 10},
+		{name: "admin-state enable", metric: "interfaces", valueName: "interface/admin-state", in: "enable", want: 1},
+		{name: "admin-state disable", metric: "interfaces", valueName: "interface/admin-state", in: "disable", want: 0},
+		{name: "bgp session-state", metric: "bgp", valueName: "neighbor/session-state", in: "established", want: 6},
+		{name: "bgp session-state is not a generic state", metric: "bgp", valueName: "neighbor/session-state", in: "active", want: 3},
+		{name: "identityref prefix", metric: "interfaces", valueName: "interface/oper-state", in: "srl_nokia-if:up", want: 1},
+		{name: "unknown state", metric: "interfaces", valueName: "interface/oper-state", in: "sideways", wantErr: true},
+		{name: "state map does not apply to other leaves", metric: "interfaces", valueName: "interface/description", in: "up", wantErr: true},
+		{name: "metric value map", metric: "interfaces", valueName: "lag/lacp-mode", in: "lacp-passive", want: 2},
+		{name: "leaf value map by path", metric: "interfaces", valueName: "/interface/oper-down-reason", in: "lag-member-down", want: 2},
+		{name: "leaf value map overrides the built-in one", metric: "interfaces", valueName: "transceiver/oper-state", in: "down", want: 20},
+		{name: "leaf value map of another leaf", metric: "interfaces", valueName: "ethernet/oper-down-reason", in: "lag-member-down", wantErr: true},
+		{name: "custom metric value map", metric: "bgp", valueName: "afi-safi/afi-safi-name", in: "srl_nokia-common:ipv4-unicast", want: 4},
+		{name: "date-time", metric: "interfaces", valueName: "interface/last-change", in: "2023-11-14T22:13:20.5Z", want: 1700000000.5},
+		{name: "nil value", metric: "interfaces", valueName: "interface/oper-state", in: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.convertValue(tt.metric, tt.valueName, tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("convertValue(%q, %v): expected an error, got %v", tt.valueName, tt.in, got)
+				}
+				if !math.IsNaN(got) {
+					t.Errorf("convertValue(%q, %v): expected NaN with the error, got %v", tt.valueName, tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("convertValue(%q, %v): unexpected error: %v", tt.valueName, tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("convertValue(%q, %v): got %v, expected %v", tt.valueName, tt.in, got, tt.want)
+			}
+		})
+	}
+}